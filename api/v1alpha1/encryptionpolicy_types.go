@@ -0,0 +1,168 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ClusterNameField is the field index used to look up policies by their target cluster.
+	ClusterNameField = "spec.clusterName"
+
+	// ReadyCondition reports whether the policy was accepted and is applied to its target cluster.
+	ReadyCondition = "Ready"
+
+	// AcceptedReason is used when the policy is valid and applied to the cluster.
+	AcceptedReason = "Accepted"
+	// ClusterNotFoundReason is used when the target cluster does not exist in the policy namespace.
+	ClusterNotFoundReason = "ClusterNotFound"
+	// ConflictReason is used when another policy already targets the same cluster.
+	ConflictReason = "Conflict"
+	// InvalidSpecReason is used when the policy spec can not be applied by the operator.
+	InvalidSpecReason = "InvalidSpec"
+)
+
+// ProviderType is the name of the encryption provider used to encrypt resources in etcd.
+// +kubebuilder:validation:Enum=secretbox;aesgcm;kms
+type ProviderType string
+
+const (
+	// ProviderSecretbox uses XSalsa20 and Poly1305 with a locally generated key.
+	ProviderSecretbox ProviderType = "secretbox"
+	// ProviderAESGCM uses AES-GCM with a locally generated 256-bit key, keys are rotated automatically
+	// because GCM nonces must not be reused with the same key.
+	ProviderAESGCM ProviderType = "aesgcm"
+	// ProviderKMS uses KMS v2 envelope encryption with the KMS plugin configured in the operator,
+	// keys never leave the KMS and are not rotated by the operator.
+	ProviderKMS ProviderType = "kms"
+)
+
+// RetentionPolicy decides what happens to the key material of a cluster when the cluster is deleted.
+// +kubebuilder:validation:Enum=Delete;Retain;Archive
+type RetentionPolicy string
+
+const (
+	// RetentionPolicyDelete deletes the encryption provider config secret together with the cluster.
+	RetentionPolicyDelete RetentionPolicy = "Delete"
+	// RetentionPolicyRetain keeps the encryption provider config secret after the cluster was deleted.
+	RetentionPolicyRetain RetentionPolicy = "Retain"
+	// RetentionPolicyArchive moves the key material into an archive secret when the cluster is deleted.
+	RetentionPolicyArchive RetentionPolicy = "Archive"
+)
+
+// EncryptionPolicySpec defines the desired encryption configuration of a workload cluster.
+type EncryptionPolicySpec struct {
+	// ClusterName is the name of the Cluster CR in the same namespace this policy applies to.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="clusterName is immutable"
+	ClusterName string `json:"clusterName"`
+
+	// Provider is the encryption provider used for newly generated keys, defaults to the provider of the operator.
+	// +optional
+	Provider ProviderType `json:"provider,omitempty"`
+
+	// Resources is the list of kubernetes resources which are encrypted in etcd, defaults to the resources of the operator.
+	// Resources are specified as resource.group, *.group for all resources of a group or *.* for all resources.
+	// +kubebuilder:validation:MinItems=1
+	// +optional
+	Resources []string `json:"resources,omitempty"`
+
+	// Rotation configures the periodic rotation of the encryption key.
+	// +optional
+	Rotation RotationSpec `json:"rotation,omitempty"`
+
+	// RetentionPolicy decides what happens to the key material when the cluster is deleted,
+	// defaults to the retention policy of the operator.
+	// +optional
+	RetentionPolicy RetentionPolicy `json:"retentionPolicy,omitempty"`
+}
+
+// RotationSpec configures the periodic rotation of the encryption key.
+type RotationSpec struct {
+	// Enabled turns on periodic key rotation for the cluster.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Period is the maximum age of the encryption key before a new one is rotated in.
+	// Defaults to the operator wide key rotation period.
+	// +optional
+	Period *metav1.Duration `json:"period,omitempty"`
+
+	// MaintenanceWindow restricts when new rotations start and resources are rewritten.
+	// Defaults to the operator wide maintenance window.
+	// +optional
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+}
+
+// MaintenanceWindow is a recurring time window, it opens on every activation of the schedule and stays open for the duration.
+type MaintenanceWindow struct {
+	// Schedule is the cron expression of the window starts, e.g. "0 2 * * SAT".
+	// The time zone defaults to UTC and can be set with a CRON_TZ= prefix.
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// Duration is the time the window stays open after each start.
+	Duration metav1.Duration `json:"duration"`
+}
+
+// EncryptionPolicyStatus defines the observed state of EncryptionPolicy.
+type EncryptionPolicyStatus struct {
+	// ObservedGeneration is the latest generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions defines current state of the policy.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Rotation is the state of the key rotation of the target cluster.
+	// +optional
+	Rotation *RotationStatus `json:"rotation,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=encpol
+// +kubebuilder:printcolumn:name="Cluster",type="string",JSONPath=".spec.clusterName"
+// +kubebuilder:printcolumn:name="Provider",type="string",JSONPath=".spec.provider"
+// +kubebuilder:printcolumn:name="Rotation",type="boolean",JSONPath=".spec.rotation.enabled"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.rotation.phase"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// EncryptionPolicy is the Schema for the encryptionpolicies API
+type EncryptionPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EncryptionPolicySpec   `json:"spec,omitempty"`
+	Status EncryptionPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// EncryptionPolicyList contains a list of EncryptionPolicy
+type EncryptionPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EncryptionPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EncryptionPolicy{}, &EncryptionPolicyList{})
+}
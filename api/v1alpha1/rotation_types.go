@@ -0,0 +1,172 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RotationStatusAnnotation stores the JSON encoded RotationStatus on the encryption provider config secret.
+// The secret is the source of truth, so the key material and the rotation phase are always updated together.
+const RotationStatusAnnotation = "encryption.giantswarm.io/rotation-status"
+
+// CancelRotationAnnotation on the encryption provider config secret cancels the rotation in progress,
+// the new key is removed again as long as the resources were not rewritten with it.
+const CancelRotationAnnotation = "encryption.giantswarm.io/cancel-rotation"
+
+// RotationPhase is a step of the key rotation process.
+// +kubebuilder:validation:Enum=KeyAdded;WaitingForControlPlane;RewritingSecrets;PruningOldKey;RollingOutControlPlane;RevertingKey;Completed;Cancelled;Failed
+type RotationPhase string
+
+const (
+	// RotationPhaseKeyAdded means the new key was added to the encryption config at the first position.
+	RotationPhaseKeyAdded RotationPhase = "KeyAdded"
+	// RotationPhaseWaitingForControlPlane means the operator waits until all control plane nodes use the new config.
+	RotationPhaseWaitingForControlPlane RotationPhase = "WaitingForControlPlane"
+	// RotationPhaseRewritingSecrets means all secrets in the workload cluster are rewritten with the new key.
+	RotationPhaseRewritingSecrets RotationPhase = "RewritingSecrets"
+	// RotationPhasePruningOldKey means the old key is removed from the encryption config.
+	RotationPhasePruningOldKey RotationPhase = "PruningOldKey"
+	// RotationPhaseRollingOutControlPlane means the operator waits until the control plane rolled out the config without the old key.
+	RotationPhaseRollingOutControlPlane RotationPhase = "RollingOutControlPlane"
+	// RotationPhaseRevertingKey means the rotation was cancelled and the operator waits until all control plane nodes use the config without the new key.
+	RotationPhaseRevertingKey RotationPhase = "RevertingKey"
+	// RotationPhaseCompleted means the last rotation finished successfully.
+	RotationPhaseCompleted RotationPhase = "Completed"
+	// RotationPhaseCancelled means the last rotation was cancelled and the new key removed, no rotation is started until it is forced.
+	RotationPhaseCancelled RotationPhase = "Cancelled"
+	// RotationPhaseFailed means the last step failed, the rotation is retried from FailedPhase.
+	RotationPhaseFailed RotationPhase = "Failed"
+)
+
+// RotationPhases lists all rotation phases.
+var RotationPhases = []RotationPhase{
+	RotationPhaseKeyAdded,
+	RotationPhaseWaitingForControlPlane,
+	RotationPhaseRewritingSecrets,
+	RotationPhasePruningOldKey,
+	RotationPhaseRollingOutControlPlane,
+	RotationPhaseRevertingKey,
+	RotationPhaseCompleted,
+	RotationPhaseCancelled,
+	RotationPhaseFailed,
+}
+
+// InProgress returns true if the phase belongs to a rotation that has not finished yet.
+func (p RotationPhase) InProgress() bool {
+	return p != "" && p != RotationPhaseCompleted && p != RotationPhaseCancelled
+}
+
+// Cancellable returns true if the rotation can be cancelled in the phase, the resources were not rewritten with the new key yet.
+func (p RotationPhase) Cancellable() bool {
+	return p == RotationPhaseKeyAdded || p == RotationPhaseWaitingForControlPlane
+}
+
+// RotationStatus is the observed state of the key rotation of a cluster.
+type RotationStatus struct {
+	// Phase is the current phase of the rotation.
+	// +optional
+	Phase RotationPhase `json:"phase,omitempty"`
+
+	// FailedPhase is the phase that failed, set only when Phase is Failed.
+	// +optional
+	FailedPhase RotationPhase `json:"failedPhase,omitempty"`
+
+	// Message is a human readable message about the current phase, e.g. the last error.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// StartedAt is the time the current or last rotation was started.
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// NewKeyProvider is the provider of the key added by the current or last rotation.
+	// +optional
+	NewKeyProvider string `json:"newKeyProvider,omitempty"`
+
+	// NewKeyName is the name of the key added by the current or last rotation, for kms the name of the KMS plugin.
+	// +optional
+	NewKeyName string `json:"newKeyName,omitempty"`
+
+	// ResourcesChanged is true if the rotation changed the encrypted resources, such a rotation can not be cancelled.
+	// +optional
+	ResourcesChanged bool `json:"resourcesChanged,omitempty"`
+
+	// PhaseTransitions holds the last time each phase of the current or last rotation was entered.
+	// +optional
+	PhaseTransitions []PhaseTransition `json:"phaseTransitions,omitempty"`
+
+	// RewriteCheckpoint is the progress of the RewritingSecrets phase, an interrupted rewrite resumes from it.
+	// +optional
+	RewriteCheckpoint *RewriteCheckpoint `json:"rewriteCheckpoint,omitempty"`
+}
+
+// RewriteCheckpoint records which objects were already rewritten with the new key.
+type RewriteCheckpoint struct {
+	// CompletedResources are the resources whose objects were all rewritten.
+	// +optional
+	CompletedResources []string `json:"completedResources,omitempty"`
+
+	// Resource is the resource which is currently rewritten.
+	// +optional
+	Resource string `json:"resource,omitempty"`
+
+	// Continue is the continue token of the next page of Resource.
+	// +optional
+	Continue string `json:"continue,omitempty"`
+
+	// Rewritten is the number of objects rewritten in the current rotation.
+	// +optional
+	Rewritten int64 `json:"rewritten,omitempty"`
+}
+
+// PhaseTransition records when a rotation phase was entered.
+type PhaseTransition struct {
+	// Phase is the entered phase.
+	Phase RotationPhase `json:"phase"`
+
+	// Time is the time the phase was entered.
+	Time metav1.Time `json:"time"`
+}
+
+// SetPhase moves the rotation into the given phase and records the transition time.
+func (s *RotationStatus) SetPhase(phase RotationPhase, now metav1.Time) {
+	s.Phase = phase
+	if phase != RotationPhaseFailed {
+		s.FailedPhase = ""
+	}
+	s.Message = ""
+
+	for i := range s.PhaseTransitions {
+		if s.PhaseTransitions[i].Phase == phase {
+			s.PhaseTransitions[i].Time = now
+			return
+		}
+	}
+	s.PhaseTransitions = append(s.PhaseTransitions, PhaseTransition{Phase: phase, Time: now})
+}
+
+// PhaseTime returns the last time the given phase was entered, nil if the phase was not entered yet.
+func (s *RotationStatus) PhaseTime(phase RotationPhase) *metav1.Time {
+	for i := range s.PhaseTransitions {
+		if s.PhaseTransitions[i].Phase == phase {
+			return &s.PhaseTransitions[i].Time
+		}
+	}
+
+	return nil
+}
@@ -17,31 +17,63 @@ limitations under the License.
 package controllers
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"maps"
 	"time"
 
 	"github.com/blang/semver"
 	"github.com/giantswarm/k8smetadata/pkg/label"
 	"github.com/giantswarm/microerror"
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
-	"sigs.k8s.io/cluster-api/util/patch"
+	capiconditions "sigs.k8s.io/cluster-api/util/deprecated/v1beta1/conditions"
+	"sigs.k8s.io/cluster-api/util/deprecated/v1beta1/patch"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/conditions"
 	"github.com/giantswarm/encryption-provider-operator/pkg/encryption"
+	"github.com/giantswarm/encryption-provider-operator/pkg/fleet"
 	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+	"github.com/giantswarm/encryption-provider-operator/pkg/project"
+	"github.com/giantswarm/encryption-provider-operator/pkg/remote"
 )
 
 // ClusterReconciler reconciles a Cluster object
 type ClusterReconciler struct {
-	AppCatalog               string
-	DefaultKeyRotationPeriod time.Duration
-	RegistryDomain           string
-	FromReleaseVersion       string
+	AppCatalog                 string
+	ClusterCache               *remote.ClusterCache
+	controller                 controller.Controller
+	DefaultKeyRotationPeriod   time.Duration
+	DefaultProvider            v1alpha1.ProviderType
+	DefaultResources           []string
+	KMS                        encryption.KMSConfig
+	MaxAESGCMKeyRotationPeriod time.Duration
+	RegistryDomain             string
+	Rewrite                    encryption.RewriteConfig
+	StorageVersionMigration    bool
+	ControlPlaneRollout        bool
+	OwnerReference             bool
+	RetentionPolicy            v1alpha1.RetentionPolicy
+	KeyEscrow                  encryption.KeyEscrow
+	MaintenanceWindow          *encryption.MaintenanceWindow
+	Fleet                      *fleet.Coordinator
+	FromReleaseVersion         string
 
 	client.Client
 	Log    logr.Logger
@@ -51,6 +83,8 @@ type ClusterReconciler struct {
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=cluster,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=cluster/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=cluster/finalizers,verbs=update
+// +kubebuilder:rbac:groups=encryption.giantswarm.io,resources=encryptionpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=*,verbs=get;list;watch;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -61,8 +95,13 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	logger := r.Log.WithValues("namespace", req.Namespace, "cluster", req.Name)
 
 	cluster := &capi.Cluster{}
-	if err := r.Get(ctx, req.NamespacedName, cluster); err != nil {
-		logger.Error(err, "Cluster does not exist")
+	if err := r.Get(ctx, req.NamespacedName, cluster); apierrors.IsNotFound(err) {
+		// the cluster is gone or a secret of a non-existing cluster was mapped to it
+		r.ClusterCache.Delete(req.NamespacedName)
+		logger.Info("Cluster does not exist")
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		logger.Error(err, "failed to get Cluster")
 		return ctrl.Result{}, microerror.Mask(err)
 	}
 
@@ -88,15 +127,35 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		logger.Info("did not found release label on cluster CR, assuming CAPI release")
 	}
 
+	policy, err := encryptionPolicyForCluster(ctx, r.Client, cluster)
+	if err != nil {
+		logger.Error(err, "failed to get encryption policy for cluster")
+		return ctrl.Result{}, microerror.Mask(err)
+	}
+
 	var encryptionService *encryption.Service
 	{
 		c := encryption.Config{
-			AppCatalog:               r.AppCatalog,
-			Cluster:                  cluster,
-			CtrlClient:               r.Client,
-			DefaultKeyRotationPeriod: r.DefaultKeyRotationPeriod,
-			RegistryDomain:           r.RegistryDomain,
-			Logger:                   logger,
+			AppCatalog:                 r.AppCatalog,
+			Cluster:                    cluster,
+			ClusterCache:               r.ClusterCache,
+			CtrlClient:                 r.Client,
+			DefaultKeyRotationPeriod:   r.DefaultKeyRotationPeriod,
+			DefaultProvider:            r.DefaultProvider,
+			DefaultResources:           r.DefaultResources,
+			KMS:                        r.KMS,
+			MaxAESGCMKeyRotationPeriod: r.MaxAESGCMKeyRotationPeriod,
+			Policy:                     policy,
+			RegistryDomain:             r.RegistryDomain,
+			Rewrite:                    r.Rewrite,
+			StorageVersionMigration:    r.StorageVersionMigration,
+			ControlPlaneRollout:        r.ControlPlaneRollout,
+			OwnerReference:             r.OwnerReference,
+			DefaultRetentionPolicy:     r.RetentionPolicy,
+			KeyEscrow:                  r.KeyEscrow,
+			MaintenanceWindow:          r.MaintenanceWindow,
+			Fleet:                      r.Fleet,
+			Logger:                     logger,
 		}
 
 		encryptionService, err = encryption.New(c)
@@ -105,11 +164,32 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			return ctrl.Result{}, microerror.Mask(err)
 		}
 	}
+
 	patchHelper, err := patch.NewHelper(cluster, r.Client)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
+	if encryption.IsPaused(cluster) {
+		// report the encryption state but leave the keys, the finalizer and the workload cluster alone
+		err = encryptionService.ReportStatus()
+		if err != nil {
+			logger.Error(err, "failed to report status of paused cluster")
+		}
+
+		patchErr := r.patchConditions(ctx, patchHelper, cluster)
+		if patchErr != nil {
+			logger.Error(patchErr, "failed to patch encryption conditions on Cluster CR")
+			return ctrl.Result{}, microerror.Mask(patchErr)
+		}
+		if err != nil {
+			return ctrl.Result{}, microerror.Mask(err)
+		}
+
+		logger.Info("cluster is paused, skipping encryption reconciliation")
+		return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
+	}
+
 	if cluster.DeletionTimestamp != nil {
 		// clean
 		err = encryptionService.Delete()
@@ -137,11 +217,38 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		}
 
 		// reconcile
+		patchHelper, err = patch.NewHelper(cluster, r.Client)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 		err = encryptionService.Reconcile()
 		if err != nil {
 			logger.Error(err, "failed to reconcile resource")
+		}
+
+		// report encryption conditions on the Cluster even if the reconciliation failed
+		patchErr := r.patchConditions(ctx, patchHelper, cluster)
+		if patchErr != nil {
+			logger.Error(patchErr, "failed to patch encryption conditions on Cluster CR")
+		}
+
+		if err != nil {
 			return ctrl.Result{}, microerror.Mask(err)
 		}
+		if patchErr != nil {
+			return ctrl.Result{}, microerror.Mask(patchErr)
+		}
+
+		if capiconditions.IsTrue(cluster, conditions.EncryptionKeyRotationInProgress) {
+			// react to the workload cluster while the rotation waits for it instead of waiting for the next requeue
+			err = r.watchWorkloadCluster(ctx, cluster)
+			if err != nil {
+				logger.Error(err, "failed to watch workload cluster, falling back to periodic reconciliation")
+			}
+		} else {
+			// the rotation completed or was cancelled, the workload cluster is not watched anymore
+			r.ClusterCache.StopWatches(client.ObjectKeyFromObject(cluster))
+		}
 	}
 
 	return ctrl.Result{
@@ -150,9 +257,191 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}, nil
 }
 
+// patchConditions patches the encryption conditions of the cluster, they are owned by the operator
+// so a conflicting change of the conditions in the meantime is overwritten
+func (r *ClusterReconciler) patchConditions(ctx context.Context, patchHelper *patch.Helper, cluster *capi.Cluster) error {
+	return patchHelper.Patch(ctx, cluster,
+		patch.WithOwnedConditions{Conditions: conditions.Owned},
+		patch.WithOwnedV1Beta2Conditions{Conditions: conditions.OwnedV1Beta2()},
+	)
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	c, err := ctrl.NewControllerManagedBy(mgr).
 		For(&capi.Cluster{}).
-		Complete(r)
+		Watches(&v1alpha1.EncryptionPolicy{}, handler.EnqueueRequestsFromMapFunc(encryptionPolicyToCluster)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(encryptionSecretToCluster), builder.WithPredicates(isEncryptionProviderConfigSecret(), encryptionSecretChanged())).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(legacySecretToCluster), builder.WithPredicates(isLegacySecret(), encryptionSecretChanged())).
+		Build(r)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	r.controller = c
+
+	return nil
+}
+
+// watchWorkloadCluster enqueues the cluster when the hash secret or a control plane node changes in the workload cluster
+func (r *ClusterReconciler) watchWorkloadCluster(ctx context.Context, cluster *capi.Cluster) error {
+	clusterKey := client.ObjectKeyFromObject(cluster)
+	toCluster := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: clusterKey}}
+	})
+
+	err := r.ClusterCache.Watch(ctx, clusterKey, remote.WatchInput{
+		Name:         "encryption-provider-config-shake256",
+		Watcher:      r.controller,
+		Kind:         &corev1.Secret{},
+		EventHandler: toCluster,
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = r.ClusterCache.Watch(ctx, clusterKey, remote.WatchInput{
+		Name:         "control-plane-nodes",
+		Watcher:      r.controller,
+		Kind:         &corev1.Node{},
+		EventHandler: toCluster,
+		Predicates:   []predicate.Predicate{controlPlaneNodeChanged()},
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// WorkloadClusterCacheByObject restricts the objects cached for the watches on the workload clusters to the hash secret,
+// nodes are not restricted as control plane nodes have one of two labels
+func WorkloadClusterCacheByObject() map[client.Object]cache.ByObject {
+	return map[client.Object]cache.ByObject{
+		&corev1.Secret{}: {
+			Namespaces: map[string]cache.Config{
+				encryption.EncryptionProviderConfigShake256SecretNamespace: {},
+			},
+			Field: fields.OneTermEqualSelector("metadata.name", encryption.EncryptionProviderConfigShake256SecretName),
+		},
+	}
+}
+
+// controlPlaneNodeChanged filters the events of control plane nodes which join, leave or change their readiness,
+// so the frequent status updates of the nodes do not trigger reconciliations
+func controlPlaneNodeChanged() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return isControlPlaneNode(e.Object)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return isControlPlaneNode(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !isControlPlaneNode(e.ObjectNew) {
+				return false
+			}
+			oldNode, ok := e.ObjectOld.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			newNode, ok := e.ObjectNew.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			return key.IsNodeReady(oldNode) != key.IsNodeReady(newNode)
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+func isControlPlaneNode(o client.Object) bool {
+	for _, l := range key.MasterNodeLabels {
+		if _, ok := o.GetLabels()[l]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encryptionSecretToCluster enqueues the cluster of the encryption provider config secret.
+func encryptionSecretToCluster(_ context.Context, o client.Object) []reconcile.Request {
+	clusterName := o.GetLabels()[capi.ClusterNameLabel]
+	if clusterName == "" {
+		return nil
+	}
+
+	return []reconcile.Request{
+		{NamespacedName: client.ObjectKey{Namespace: o.GetNamespace(), Name: clusterName}},
+	}
+}
+
+// isEncryptionProviderConfigSecret filters the events of all secrets but the encryption provider config secrets,
+// which are labelled with the operator and their cluster
+func isEncryptionProviderConfigSecret() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(o client.Object) bool {
+		clusterName := o.GetLabels()[capi.ClusterNameLabel]
+		return o.GetLabels()[label.ManagedBy] == project.Name() && clusterName != "" && o.GetName() == key.SecretName(clusterName)
+	})
+}
+
+// legacySecretToCluster enqueues the cluster of the legacy encryption secret, which has no cluster label,
+// so the cluster is taken from the name of the secret.
+func legacySecretToCluster(_ context.Context, o client.Object) []reconcile.Request {
+	clusterName, ok := key.ClusterNameFromSecretName(o.GetName())
+	if !ok {
+		return nil
+	}
+
+	return []reconcile.Request{
+		{NamespacedName: client.ObjectKey{Namespace: o.GetNamespace(), Name: clusterName}},
+	}
+}
+
+// isLegacySecret filters the events of all secrets but the legacy <cluster>-encryption secrets
+func isLegacySecret() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(o client.Object) bool {
+		clusterName, ok := key.ClusterNameFromSecretName(o.GetName())
+		return ok && o.GetName() == key.LegacySecretName(clusterName)
+	})
+}
+
+// encryptionSecretChanged filters the updates of secrets which only changed the rotation status,
+// the operator updates it itself during the rotation
+func encryptionSecretChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSecret, ok := e.ObjectOld.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			newSecret, ok := e.ObjectNew.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+
+			oldAnnotations := maps.Clone(oldSecret.GetAnnotations())
+			newAnnotations := maps.Clone(newSecret.GetAnnotations())
+			delete(oldAnnotations, v1alpha1.RotationStatusAnnotation)
+			delete(newAnnotations, v1alpha1.RotationStatusAnnotation)
+
+			return !maps.Equal(oldAnnotations, newAnnotations) ||
+				!maps.EqualFunc(oldSecret.Data, newSecret.Data, bytes.Equal) ||
+				!newSecret.GetDeletionTimestamp().Equal(oldSecret.GetDeletionTimestamp())
+		},
+	}
+}
+
+// encryptionPolicyToCluster enqueues the cluster targeted by the EncryptionPolicy.
+func encryptionPolicyToCluster(_ context.Context, o client.Object) []reconcile.Request {
+	policy, ok := o.(*v1alpha1.EncryptionPolicy)
+	if !ok {
+		return nil
+	}
+
+	return []reconcile.Request{
+		{NamespacedName: client.ObjectKey{Namespace: policy.Namespace, Name: policy.Spec.ClusterName}},
+	}
 }
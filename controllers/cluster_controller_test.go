@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/giantswarm/k8smetadata/pkg/label"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+	"github.com/giantswarm/encryption-provider-operator/pkg/project"
+)
+
+func Test_secretToCluster(t *testing.T) {
+	managedLabels := map[string]string{label.ManagedBy: project.Name(), capi.ClusterNameLabel: "abc12"}
+	request := []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: "org-test", Name: "abc12"}}}
+
+	testCases := []struct {
+		name             string
+		secretName       string
+		labels           map[string]string
+		expectedRequests []reconcile.Request
+		expectedLegacy   []reconcile.Request
+	}{
+		{
+			name:             "case 0: labelled encryption provider config secret",
+			secretName:       key.SecretName("abc12"),
+			labels:           managedLabels,
+			expectedRequests: request,
+		},
+		{
+			name:           "case 1: unlabelled legacy secret",
+			secretName:     key.LegacySecretName("abc12"),
+			expectedLegacy: request,
+		},
+		{
+			name:       "case 2: unlabelled encryption provider config secret",
+			secretName: key.SecretName("abc12"),
+		},
+		{
+			name:       "case 3: key history secret",
+			secretName: key.KeyHistorySecretName("abc12"),
+			labels:     managedLabels,
+		},
+		{
+			name:       "case 4: kubeconfig secret",
+			secretName: "abc12-kubeconfig",
+			labels:     map[string]string{capi.ClusterNameLabel: "abc12"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: tc.secretName, Namespace: "org-test", Labels: tc.labels}}
+
+			var requests []reconcile.Request
+			if isEncryptionProviderConfigSecret().Create(event.CreateEvent{Object: secret}) {
+				requests = encryptionSecretToCluster(ctx, secret)
+			}
+			if !reflect.DeepEqual(requests, tc.expectedRequests) {
+				t.Fatalf("expected requests %v but got %v", tc.expectedRequests, requests)
+			}
+
+			var legacyRequests []reconcile.Request
+			if isLegacySecret().Create(event.CreateEvent{Object: secret}) {
+				legacyRequests = legacySecretToCluster(ctx, secret)
+			}
+			if !reflect.DeepEqual(legacyRequests, tc.expectedLegacy) {
+				t.Fatalf("expected legacy requests %v but got %v", tc.expectedLegacy, legacyRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	"sigs.k8s.io/cluster-api/util/patch"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/encryption"
+)
+
+// EncryptionPolicyReconciler reconciles an EncryptionPolicy object
+type EncryptionPolicyReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=encryption.giantswarm.io,resources=encryptionpolicies,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=encryption.giantswarm.io,resources=encryptionpolicies/status,verbs=get;update;patch
+
+// Reconcile validates the EncryptionPolicy and reports in its status whether it is applied to the target cluster.
+func (r *EncryptionPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := r.Log.WithValues("namespace", req.Namespace, "encryptionpolicy", req.Name)
+
+	policy := &v1alpha1.EncryptionPolicy{}
+	err := r.Get(ctx, req.NamespacedName, policy)
+	if apierrors.IsNotFound(err) {
+		// policy was deleted, nothing to do
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		return ctrl.Result{}, microerror.Mask(err)
+	}
+
+	patchHelper, err := patch.NewHelper(policy, r.Client)
+	if err != nil {
+		return ctrl.Result{}, microerror.Mask(err)
+	}
+
+	condition, err := r.validate(ctx, policy)
+	if err != nil {
+		logger.Error(err, "failed to validate encryption policy")
+		return ctrl.Result{}, microerror.Mask(err)
+	}
+	condition.ObservedGeneration = policy.Generation
+	meta.SetStatusCondition(&policy.Status.Conditions, condition)
+	policy.Status.ObservedGeneration = policy.Generation
+
+	err = patchHelper.Patch(ctx, policy)
+	if err != nil {
+		logger.Error(err, "failed to update encryption policy status")
+		return ctrl.Result{}, microerror.Mask(err)
+	}
+
+	if condition.Status != metav1.ConditionTrue {
+		logger.Info(fmt.Sprintf("encryption policy is not applied: %s", condition.Message))
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// validate returns the Ready condition describing whether the policy can be applied to its cluster.
+func (r *EncryptionPolicyReconciler) validate(ctx context.Context, policy *v1alpha1.EncryptionPolicy) (metav1.Condition, error) {
+	if len(policy.Spec.Resources) > 0 {
+		err := encryption.ValidateResources(policy.Spec.Resources)
+		if err != nil {
+			return metav1.Condition{
+				Type:    v1alpha1.ReadyCondition,
+				Status:  metav1.ConditionFalse,
+				Reason:  v1alpha1.InvalidSpecReason,
+				Message: err.Error(),
+			}, nil
+		}
+	}
+
+	err := encryption.ValidateKeyRotationPeriod(policy.Spec.Rotation.Period)
+	if err != nil {
+		return metav1.Condition{
+			Type:    v1alpha1.ReadyCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  v1alpha1.InvalidSpecReason,
+			Message: err.Error(),
+		}, nil
+	}
+
+	err = encryption.ValidateMaintenanceWindow(policy.Spec.Rotation.MaintenanceWindow)
+	if err != nil {
+		return metav1.Condition{
+			Type:    v1alpha1.ReadyCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  v1alpha1.InvalidSpecReason,
+			Message: err.Error(),
+		}, nil
+	}
+
+	var cluster capi.Cluster
+	err = r.Get(ctx, client.ObjectKey{Namespace: policy.Namespace, Name: policy.Spec.ClusterName}, &cluster)
+	if apierrors.IsNotFound(err) {
+		return metav1.Condition{
+			Type:    v1alpha1.ReadyCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  v1alpha1.ClusterNotFoundReason,
+			Message: fmt.Sprintf("cluster %s/%s does not exist", policy.Namespace, policy.Spec.ClusterName),
+		}, nil
+	} else if err != nil {
+		return metav1.Condition{}, microerror.Mask(err)
+	}
+
+	policies, err := listEncryptionPoliciesForCluster(ctx, r.Client, policy.Namespace, policy.Spec.ClusterName)
+	if err != nil {
+		return metav1.Condition{}, microerror.Mask(err)
+	}
+	if active := activeEncryptionPolicy(policies); active != nil && active.Name != policy.Name {
+		return metav1.Condition{
+			Type:    v1alpha1.ReadyCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  v1alpha1.ConflictReason,
+			Message: fmt.Sprintf("cluster %s is already targeted by encryption policy %s", policy.Spec.ClusterName, active.Name),
+		}, nil
+	}
+
+	return metav1.Condition{
+		Type:    v1alpha1.ReadyCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  v1alpha1.AcceptedReason,
+		Message: fmt.Sprintf("policy is applied to cluster %s", policy.Spec.ClusterName),
+	}, nil
+}
+
+// clusterToEncryptionPolicies enqueues all policies targeting the cluster.
+func (r *EncryptionPolicyReconciler) clusterToEncryptionPolicies(ctx context.Context, o client.Object) []reconcile.Request {
+	policies, err := listEncryptionPoliciesForCluster(ctx, r.Client, o.GetNamespace(), o.GetName())
+	if err != nil {
+		r.Log.Error(err, "failed to list encryption policies for cluster", "namespace", o.GetNamespace(), "cluster", o.GetName())
+		return nil
+	}
+
+	return encryptionPolicyRequests(policies)
+}
+
+// encryptionPolicyToSiblings enqueues all policies targeting the same cluster, so conflicts are
+// re-evaluated whenever one of them changes or is deleted.
+func (r *EncryptionPolicyReconciler) encryptionPolicyToSiblings(ctx context.Context, o client.Object) []reconcile.Request {
+	policy, ok := o.(*v1alpha1.EncryptionPolicy)
+	if !ok {
+		return nil
+	}
+
+	policies, err := listEncryptionPoliciesForCluster(ctx, r.Client, policy.Namespace, policy.Spec.ClusterName)
+	if err != nil {
+		r.Log.Error(err, "failed to list encryption policies for cluster", "namespace", policy.Namespace, "cluster", policy.Spec.ClusterName)
+		return nil
+	}
+
+	return encryptionPolicyRequests(policies)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *EncryptionPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1alpha1.EncryptionPolicy{}, v1alpha1.ClusterNameField, encryptionPolicyClusterName)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.EncryptionPolicy{}).
+		Watches(&v1alpha1.EncryptionPolicy{}, handler.EnqueueRequestsFromMapFunc(r.encryptionPolicyToSiblings)).
+		Watches(&capi.Cluster{}, handler.EnqueueRequestsFromMapFunc(r.clusterToEncryptionPolicies)).
+		Complete(r)
+}
+
+// encryptionPolicyClusterName indexes the policies by the name of their target cluster.
+func encryptionPolicyClusterName(o client.Object) []string {
+	policy, ok := o.(*v1alpha1.EncryptionPolicy)
+	if !ok {
+		return nil
+	}
+	return []string{policy.Spec.ClusterName}
+}
+
+// encryptionPolicyForCluster returns the accepted EncryptionPolicy for the cluster or nil if there is none.
+func encryptionPolicyForCluster(ctx context.Context, c client.Client, cluster *capi.Cluster) (*v1alpha1.EncryptionPolicy, error) {
+	policies, err := listEncryptionPoliciesForCluster(ctx, c, cluster.Namespace, cluster.Name)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	policy := activeEncryptionPolicy(policies)
+	if policy == nil {
+		return nil, nil
+	}
+
+	// only use the policy once the policy controller validated its latest generation
+	if policy.Status.ObservedGeneration != policy.Generation || !meta.IsStatusConditionTrue(policy.Status.Conditions, v1alpha1.ReadyCondition) {
+		return nil, nil
+	}
+
+	return policy, nil
+}
+
+func listEncryptionPoliciesForCluster(ctx context.Context, c client.Client, namespace string, clusterName string) ([]v1alpha1.EncryptionPolicy, error) {
+	var policies v1alpha1.EncryptionPolicyList
+	err := c.List(ctx, &policies,
+		client.InNamespace(namespace),
+		client.MatchingFields{v1alpha1.ClusterNameField: clusterName},
+	)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return policies.Items, nil
+}
+
+// activeEncryptionPolicy picks the oldest policy which is not being deleted,
+// all other policies targeting the same cluster are considered conflicting.
+func activeEncryptionPolicy(policies []v1alpha1.EncryptionPolicy) *v1alpha1.EncryptionPolicy {
+	var candidates []v1alpha1.EncryptionPolicy
+	for _, p := range policies {
+		if p.DeletionTimestamp == nil {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].CreationTimestamp.Equal(&candidates[j].CreationTimestamp) {
+			return candidates[i].Name < candidates[j].Name
+		}
+		return candidates[i].CreationTimestamp.Before(&candidates[j].CreationTimestamp)
+	})
+
+	return &candidates[0]
+}
+
+func encryptionPolicyRequests(policies []v1alpha1.EncryptionPolicy) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, p := range policies {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: client.ObjectKey{Namespace: p.Namespace, Name: p.Name},
+		})
+	}
+	return requests
+}
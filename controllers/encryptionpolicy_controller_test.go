@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+)
+
+func testPolicyClient(t *testing.T, objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	if err := capi.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&v1alpha1.EncryptionPolicy{}).
+		WithIndex(&v1alpha1.EncryptionPolicy{}, v1alpha1.ClusterNameField, encryptionPolicyClusterName).
+		Build()
+}
+
+func testEncryptionPolicy(name string, createdAt time.Time, spec v1alpha1.EncryptionPolicySpec) *v1alpha1.EncryptionPolicy {
+	if spec.ClusterName == "" {
+		spec.ClusterName = "abc12"
+	}
+
+	return &v1alpha1.EncryptionPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "org-test",
+			CreationTimestamp: metav1.NewTime(createdAt),
+			Generation:        1,
+		},
+		Spec: spec,
+	}
+}
+
+func Test_EncryptionPolicyReconciler_Reconcile(t *testing.T) {
+	createdAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cluster := &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "abc12", Namespace: "org-test"}}
+
+	testCases := []struct {
+		name            string
+		policies        []*v1alpha1.EncryptionPolicy
+		withoutCluster  bool
+		reconciled      string
+		expectedStatus  metav1.ConditionStatus
+		expectedReason  string
+		expectedMessage string
+	}{
+		{
+			name:           "case 0: valid policy is accepted",
+			policies:       []*v1alpha1.EncryptionPolicy{testEncryptionPolicy("policy", createdAt, v1alpha1.EncryptionPolicySpec{})},
+			reconciled:     "policy",
+			expectedStatus: metav1.ConditionTrue,
+			expectedReason: v1alpha1.AcceptedReason,
+		},
+		{
+			name:           "case 1: cluster does not exist",
+			policies:       []*v1alpha1.EncryptionPolicy{testEncryptionPolicy("policy", createdAt, v1alpha1.EncryptionPolicySpec{})},
+			withoutCluster: true,
+			reconciled:     "policy",
+			expectedStatus: metav1.ConditionFalse,
+			expectedReason: v1alpha1.ClusterNotFoundReason,
+		},
+		{
+			name: "case 2: older policy wins",
+			policies: []*v1alpha1.EncryptionPolicy{
+				testEncryptionPolicy("newer", createdAt.Add(time.Hour), v1alpha1.EncryptionPolicySpec{}),
+				testEncryptionPolicy("older", createdAt, v1alpha1.EncryptionPolicySpec{}),
+			},
+			reconciled:     "older",
+			expectedStatus: metav1.ConditionTrue,
+			expectedReason: v1alpha1.AcceptedReason,
+		},
+		{
+			name: "case 3: newer policy conflicts",
+			policies: []*v1alpha1.EncryptionPolicy{
+				testEncryptionPolicy("newer", createdAt.Add(time.Hour), v1alpha1.EncryptionPolicySpec{}),
+				testEncryptionPolicy("older", createdAt, v1alpha1.EncryptionPolicySpec{}),
+			},
+			reconciled:      "newer",
+			expectedStatus:  metav1.ConditionFalse,
+			expectedReason:  v1alpha1.ConflictReason,
+			expectedMessage: "already targeted by encryption policy older",
+		},
+		{
+			name: "case 4: negative rotation period",
+			policies: []*v1alpha1.EncryptionPolicy{testEncryptionPolicy("policy", createdAt, v1alpha1.EncryptionPolicySpec{
+				Rotation: v1alpha1.RotationSpec{Period: &metav1.Duration{Duration: -time.Hour}},
+			})},
+			reconciled:      "policy",
+			expectedStatus:  metav1.ConditionFalse,
+			expectedReason:  v1alpha1.InvalidSpecReason,
+			expectedMessage: "key rotation period must be positive",
+		},
+		{
+			name: "case 5: invalid maintenance window schedule",
+			policies: []*v1alpha1.EncryptionPolicy{testEncryptionPolicy("policy", createdAt, v1alpha1.EncryptionPolicySpec{
+				Rotation: v1alpha1.RotationSpec{MaintenanceWindow: &v1alpha1.MaintenanceWindow{Schedule: "every saturday", Duration: metav1.Duration{Duration: time.Hour}}},
+			})},
+			reconciled:      "policy",
+			expectedStatus:  metav1.ConditionFalse,
+			expectedReason:  v1alpha1.InvalidSpecReason,
+			expectedMessage: "invalid maintenance window schedule",
+		},
+		{
+			name: "case 6: maintenance window without duration",
+			policies: []*v1alpha1.EncryptionPolicy{testEncryptionPolicy("policy", createdAt, v1alpha1.EncryptionPolicySpec{
+				Rotation: v1alpha1.RotationSpec{MaintenanceWindow: &v1alpha1.MaintenanceWindow{Schedule: "0 2 * * SAT"}},
+			})},
+			reconciled:      "policy",
+			expectedStatus:  metav1.ConditionFalse,
+			expectedReason:  v1alpha1.InvalidSpecReason,
+			expectedMessage: "duration must be positive",
+		},
+		{
+			name: "case 7: invalid resource list",
+			policies: []*v1alpha1.EncryptionPolicy{testEncryptionPolicy("policy", createdAt, v1alpha1.EncryptionPolicySpec{
+				Resources: []string{"secrets", "secrets"},
+			})},
+			reconciled:      "policy",
+			expectedStatus:  metav1.ConditionFalse,
+			expectedReason:  v1alpha1.InvalidSpecReason,
+			expectedMessage: "listed more than once",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			var objects []client.Object
+			if !tc.withoutCluster {
+				objects = append(objects, cluster.DeepCopy())
+			}
+			for _, p := range tc.policies {
+				objects = append(objects, p)
+			}
+			r := &EncryptionPolicyReconciler{Client: testPolicyClient(t, objects...), Log: logr.Discard()}
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "org-test", Name: tc.reconciled}})
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			var policy v1alpha1.EncryptionPolicy
+			err = r.Get(ctx, client.ObjectKey{Namespace: "org-test", Name: tc.reconciled}, &policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy.Status.ObservedGeneration != policy.Generation {
+				t.Fatalf("expected observed generation %d but got %d", policy.Generation, policy.Status.ObservedGeneration)
+			}
+			ready := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ReadyCondition)
+			if ready == nil {
+				t.Fatalf("expected %s condition", v1alpha1.ReadyCondition)
+			}
+			if ready.Status != tc.expectedStatus || ready.Reason != tc.expectedReason {
+				t.Fatalf("expected condition %s with reason %s but got %s with reason %s", tc.expectedStatus, tc.expectedReason, ready.Status, ready.Reason)
+			}
+			if !strings.Contains(ready.Message, tc.expectedMessage) {
+				t.Fatalf("expected message matching %q but got %q", tc.expectedMessage, ready.Message)
+			}
+		})
+	}
+}
+
+func Test_encryptionPolicyForCluster(t *testing.T) {
+	createdAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cluster := &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "abc12", Namespace: "org-test"}}
+
+	// validated returns the policy as the policy controller leaves it after validating the given generation
+	validated := func(p *v1alpha1.EncryptionPolicy, observedGeneration int64, ready metav1.ConditionStatus) *v1alpha1.EncryptionPolicy {
+		p.Status.ObservedGeneration = observedGeneration
+		p.Status.Conditions = []metav1.Condition{{
+			Type:               v1alpha1.ReadyCondition,
+			Status:             ready,
+			Reason:             v1alpha1.AcceptedReason,
+			ObservedGeneration: observedGeneration,
+			LastTransitionTime: metav1.NewTime(createdAt),
+		}}
+		return p
+	}
+
+	testCases := []struct {
+		name           string
+		policies       []*v1alpha1.EncryptionPolicy
+		expectedPolicy string
+	}{
+		{
+			name:     "case 0: no policy",
+			policies: nil,
+		},
+		{
+			name:           "case 1: accepted policy",
+			policies:       []*v1alpha1.EncryptionPolicy{validated(testEncryptionPolicy("policy", createdAt, v1alpha1.EncryptionPolicySpec{}), 1, metav1.ConditionTrue)},
+			expectedPolicy: "policy",
+		},
+		{
+			name:     "case 2: policy was not validated yet",
+			policies: []*v1alpha1.EncryptionPolicy{testEncryptionPolicy("policy", createdAt, v1alpha1.EncryptionPolicySpec{})},
+		},
+		{
+			name: "case 3: generation is ahead of the observed generation",
+			policies: []*v1alpha1.EncryptionPolicy{func() *v1alpha1.EncryptionPolicy {
+				p := validated(testEncryptionPolicy("policy", createdAt, v1alpha1.EncryptionPolicySpec{}), 1, metav1.ConditionTrue)
+				p.Generation = 2
+				return p
+			}()},
+		},
+		{
+			name:     "case 4: rejected policy",
+			policies: []*v1alpha1.EncryptionPolicy{validated(testEncryptionPolicy("policy", createdAt, v1alpha1.EncryptionPolicySpec{}), 1, metav1.ConditionFalse)},
+		},
+		{
+			name: "case 5: oldest policy is used",
+			policies: []*v1alpha1.EncryptionPolicy{
+				validated(testEncryptionPolicy("newer", createdAt.Add(time.Hour), v1alpha1.EncryptionPolicySpec{}), 1, metav1.ConditionFalse),
+				validated(testEncryptionPolicy("older", createdAt, v1alpha1.EncryptionPolicySpec{}), 1, metav1.ConditionTrue),
+			},
+			expectedPolicy: "older",
+		},
+		{
+			name: "case 6: policies of other clusters are ignored",
+			policies: []*v1alpha1.EncryptionPolicy{
+				validated(testEncryptionPolicy("other", createdAt, v1alpha1.EncryptionPolicySpec{ClusterName: "def34"}), 1, metav1.ConditionTrue),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := []client.Object{cluster.DeepCopy()}
+			for _, p := range tc.policies {
+				objects = append(objects, p)
+			}
+
+			policy, err := encryptionPolicyForCluster(context.Background(), testPolicyClient(t, objects...), cluster)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			name := ""
+			if policy != nil {
+				name = policy.Name
+			}
+			if name != tc.expectedPolicy {
+				t.Fatalf("expected policy %q but got %q", tc.expectedPolicy, name)
+			}
+		})
+	}
+}
+
+func Test_activeEncryptionPolicy(t *testing.T) {
+	createdAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	deleted := testEncryptionPolicy("deleted", createdAt.Add(-time.Hour), v1alpha1.EncryptionPolicySpec{})
+	deletedAt := metav1.NewTime(createdAt)
+	deleted.DeletionTimestamp = &deletedAt
+
+	testCases := []struct {
+		name           string
+		policies       []v1alpha1.EncryptionPolicy
+		expectedPolicy string
+	}{
+		{
+			name: "case 0: oldest policy wins",
+			policies: []v1alpha1.EncryptionPolicy{
+				*testEncryptionPolicy("b", createdAt.Add(time.Hour), v1alpha1.EncryptionPolicySpec{}),
+				*testEncryptionPolicy("c", createdAt, v1alpha1.EncryptionPolicySpec{}),
+			},
+			expectedPolicy: "c",
+		},
+		{
+			name: "case 1: name decides between policies created at the same time",
+			policies: []v1alpha1.EncryptionPolicy{
+				*testEncryptionPolicy("b", createdAt, v1alpha1.EncryptionPolicySpec{}),
+				*testEncryptionPolicy("a", createdAt, v1alpha1.EncryptionPolicySpec{}),
+			},
+			expectedPolicy: "a",
+		},
+		{
+			name: "case 2: deleted policy is skipped",
+			policies: []v1alpha1.EncryptionPolicy{
+				*deleted,
+				*testEncryptionPolicy("b", createdAt, v1alpha1.EncryptionPolicySpec{}),
+			},
+			expectedPolicy: "b",
+		},
+		{
+			name:     "case 3: only deleted policies",
+			policies: []v1alpha1.EncryptionPolicy{*deleted},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := ""
+			if policy := activeEncryptionPolicy(tc.policies); policy != nil {
+				name = policy.Name
+			}
+			if name != tc.expectedPolicy {
+				t.Fatalf("expected policy %q but got %q", tc.expectedPolicy, name)
+			}
+		})
+	}
+}
@@ -18,7 +18,9 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"go.uber.org/zap/zapcore"
@@ -27,12 +29,19 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
 	"github.com/giantswarm/encryption-provider-operator/controllers"
+	"github.com/giantswarm/encryption-provider-operator/pkg/encryption"
+	"github.com/giantswarm/encryption-provider-operator/pkg/fleet"
+	"github.com/giantswarm/encryption-provider-operator/pkg/project"
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
+	"github.com/giantswarm/encryption-provider-operator/pkg/remote"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -44,14 +53,36 @@ var (
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 	_ = capi.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(restore(os.Args[2:]))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
 	var keyRotationPeriod time.Duration
+	var encryptionProvider string
+	var aesgcmKeyRotationPeriod time.Duration
+	var kmsConfig encryption.KMSConfig
+	var encryptedResources string
+	var rewriteConfig encryption.RewriteConfig
+	var rewriteQPS float64
+	var storageVersionMigration bool
+	var controlPlaneRollout bool
+	var ownerReference bool
+	var retentionPolicy string
+	var archiveRetentionPeriod time.Duration
+	var maintenanceWindowSchedule string
+	var maintenanceWindowDuration time.Duration
+	var maxConcurrentRotations int
+	var rotationJitter float64
+	var rotationWaves string
+	var vaultConfig encryption.VaultConfig
 	var registryDomain string
 	var appCatalog string
 	var fromReleaseVersion string
@@ -61,9 +92,33 @@ func main() {
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.DurationVar(&keyRotationPeriod, "key-rotation-period", time.Hour*24*180, "The default period used for key rotation.")
+	flag.StringVar(&encryptionProvider, "encryption-provider", string(v1alpha1.ProviderSecretbox), "The default encryption provider for clusters without EncryptionPolicy, one of secretbox, aesgcm or kms.")
+	flag.DurationVar(&aesgcmKeyRotationPeriod, "aesgcm-key-rotation-period", time.Hour*24*7, "The maximal key rotation period for clusters using the aesgcm encryption provider, aesgcm keys are always rotated.")
 	flag.StringVar(&registryDomain, "registry-domain", "quay.io", "The domain registry that will be used for encryption-provider-hasher app")
 	flag.StringVar(&appCatalog, "app-catalog", "giantswarm-playground-catalog", "The app catalog for encryption-provider-hasher app")
 	flag.StringVar(&fromReleaseVersion, "from-release-version", "16.3.999", "The release version of cluster from which operator will reconcile CRs, If its missing it will assume CAPI release and reconcile as well.")
+	flag.StringVar(&kmsConfig.Name, "kms-plugin-name", "", "The name of the KMS v2 plugin used by clusters with the kms encryption provider.")
+	flag.StringVar(&kmsConfig.Endpoint, "kms-plugin-endpoint", "unix:///var/run/kmsplugin/socket.sock", "The gRPC endpoint of the KMS v2 plugin on the control plane nodes.")
+	flag.DurationVar(&kmsConfig.Timeout, "kms-plugin-timeout", time.Second*3, "The timeout for gRPC calls from kube-apiserver to the KMS v2 plugin.")
+	flag.StringVar(&encryptedResources, "encrypted-resources", encryption.DefaultEncryptedResource, "Comma separated list of resources encrypted for clusters without EncryptionPolicy, e.g. secrets,configmaps,*.cert-manager.io.")
+	flag.Int64Var(&rewriteConfig.PageSize, "rewrite-page-size", encryption.DefaultRewritePageSize, "The number of objects listed per request while rewriting the encrypted resources in the workload cluster.")
+	flag.IntVar(&rewriteConfig.Workers, "rewrite-workers", encryption.DefaultRewriteWorkers, "The number of objects patched concurrently while rewriting the encrypted resources in the workload cluster.")
+	flag.Float64Var(&rewriteQPS, "rewrite-qps", 20, "The maximal number of requests per second to the workload cluster API while rewriting the encrypted resources.")
+	flag.IntVar(&rewriteConfig.Burst, "rewrite-burst", 40, "The maximal burst of requests to the workload cluster API while rewriting the encrypted resources.")
+	flag.BoolVar(&storageVersionMigration, "storage-version-migration", true, "Re-encrypt resources with StorageVersionMigrations if the workload cluster serves the storagemigration.k8s.io API.")
+	flag.BoolVar(&ownerReference, "secret-owner-reference", false, "Make the cluster the owner of its encryption provider config secret, only used with the Delete retention policy.")
+	flag.StringVar(&retentionPolicy, "key-retention-policy", string(v1alpha1.RetentionPolicyDelete), "What happens to the key material of deleted clusters without EncryptionPolicy, one of Delete, Retain or Archive.")
+	flag.StringVar(&vaultConfig.Address, "key-escrow-vault-address", "", "The address of the Vault server every generated key is escrowed to before it is used. Keys are not escrowed if empty.")
+	flag.StringVar(&vaultConfig.TokenFile, "key-escrow-vault-token-file", "", "The file with the Vault token, it is read for every request. The token is read from VAULT_TOKEN if empty.")
+	flag.StringVar(&vaultConfig.Mount, "key-escrow-vault-mount", encryption.DefaultVaultMount, "The mount path of the Vault KV v2 secrets engine keys are escrowed to.")
+	flag.StringVar(&vaultConfig.PathPrefix, "key-escrow-vault-path-prefix", encryption.DefaultVaultPathPrefix, "The path in the Vault KV v2 secrets engine below which the keys are escrowed.")
+	flag.DurationVar(&archiveRetentionPeriod, "key-archive-retention-period", 0, "The period archived key material of deleted clusters is kept before it is purged, archives are kept forever if zero.")
+	flag.StringVar(&maintenanceWindowSchedule, "maintenance-window-schedule", "", "The cron schedule of the maintenance window starts for clusters without maintenance window in the EncryptionPolicy, e.g. \"0 2 * * SAT\". New rotations start and resources are rewritten only inside the window, rotations are not restricted if empty.")
+	flag.DurationVar(&maintenanceWindowDuration, "maintenance-window-duration", time.Hour*4, "The time the maintenance window stays open after each start of the schedule.")
+	flag.IntVar(&maxConcurrentRotations, "max-concurrent-rotations", 0, "The maximal number of clusters rotating their key at the same time, further rotations wait for a free slot. Not limited if zero.")
+	flag.Float64Var(&rotationJitter, "key-rotation-jitter", 0, "The maximal fraction of the key rotation period by which a cluster rotates earlier, derived from the cluster name to spread the rotations of clusters created together. Between 0 and 1.")
+	flag.StringVar(&rotationWaves, "rotation-waves", "", "Semicolon separated label selectors of the clusters rotating together, e.g. \"environment=dev;environment=staging\". A wave starts rotations once all clusters of the previous waves completed their rotation, clusters matching no selector form the last wave.")
+	flag.BoolVar(&controlPlaneRollout, "control-plane-rollout", false, "Roll out the control plane of the workload cluster by setting rolloutAfter on its control plane object after the encryption config changed.")
 	opts := zap.Options{
 		Development: false,
 		TimeEncoder: zapcore.RFC3339TimeEncoder,
@@ -73,6 +128,48 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	switch v1alpha1.ProviderType(encryptionProvider) {
+	case v1alpha1.ProviderSecretbox, v1alpha1.ProviderAESGCM:
+	case v1alpha1.ProviderKMS:
+		if kmsConfig.Name == "" {
+			setupLog.Error(fmt.Errorf("--kms-plugin-name is required for the kms encryption provider"), "invalid flag value")
+			os.Exit(1)
+		}
+	default:
+		setupLog.Error(fmt.Errorf("unsupported encryption provider %q", encryptionProvider), "invalid flag value")
+		os.Exit(1)
+	}
+
+	if err := encryption.ValidateRetentionPolicy(v1alpha1.RetentionPolicy(retentionPolicy)); err != nil {
+		setupLog.Error(err, "invalid flag value")
+		os.Exit(1)
+	}
+
+	maintenanceWindow, err := encryption.ParseMaintenanceWindow(maintenanceWindowSchedule, maintenanceWindowDuration)
+	if err != nil {
+		setupLog.Error(err, "invalid flag value")
+		os.Exit(1)
+	}
+
+	var keyEscrow encryption.KeyEscrow
+	if vaultConfig.Address != "" {
+		vaultConfig.Token = os.Getenv("VAULT_TOKEN")
+		vaultEscrow, err := encryption.NewVaultKeyEscrow(vaultConfig)
+		if err != nil {
+			setupLog.Error(err, "invalid key escrow configuration")
+			os.Exit(1)
+		}
+		keyEscrow = vaultEscrow
+	}
+
+	rewriteConfig.QPS = float32(rewriteQPS)
+
+	defaultResources := strings.Split(encryptedResources, ",")
+	if err := encryption.ValidateResources(defaultResources); err != nil {
+		setupLog.Error(err, "invalid flag value")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
 		WebhookServer: webhook.NewServer(webhook.Options{
@@ -90,20 +187,73 @@ func main() {
 		os.Exit(1)
 	}
 
+	record.InitFromRecorder(mgr.GetEventRecorderFor(project.Name()))
+
+	clusterCache, err := remote.NewClusterCache(remote.Config{
+		CtrlClient:    mgr.GetClient(),
+		CacheByObject: controllers.WorkloadClusterCacheByObject(),
+		Logger:        ctrl.Log.WithName("cluster-cache"),
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create workload cluster cache")
+		os.Exit(1)
+	}
+
+	rotationFleet, err := fleet.New(fleet.Config{
+		CtrlClient:             mgr.GetClient(),
+		MaxConcurrentRotations: maxConcurrentRotations,
+		Jitter:                 rotationJitter,
+		Waves:                  fleet.ParseWaves(rotationWaves),
+	})
+	if err != nil {
+		setupLog.Error(err, "invalid flag value")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.ClusterReconciler{
-		AppCatalog:               appCatalog,
-		DefaultKeyRotationPeriod: keyRotationPeriod,
-		RegistryDomain:           registryDomain,
-		FromReleaseVersion:       fromReleaseVersion,
-		Client:                   mgr.GetClient(),
-		Log:                      ctrl.Log.WithName("controllers"),
-		Scheme:                   mgr.GetScheme(),
+		AppCatalog:                 appCatalog,
+		ClusterCache:               clusterCache,
+		DefaultKeyRotationPeriod:   keyRotationPeriod,
+		DefaultProvider:            v1alpha1.ProviderType(encryptionProvider),
+		DefaultResources:           defaultResources,
+		KMS:                        kmsConfig,
+		MaxAESGCMKeyRotationPeriod: aesgcmKeyRotationPeriod,
+		RegistryDomain:             registryDomain,
+		Rewrite:                    rewriteConfig,
+		StorageVersionMigration:    storageVersionMigration,
+		ControlPlaneRollout:        controlPlaneRollout,
+		OwnerReference:             ownerReference,
+		RetentionPolicy:            v1alpha1.RetentionPolicy(retentionPolicy),
+		KeyEscrow:                  keyEscrow,
+		MaintenanceWindow:          maintenanceWindow,
+		Fleet:                      rotationFleet,
+		FromReleaseVersion:         fromReleaseVersion,
+		Client:                     mgr.GetClient(),
+		Log:                        ctrl.Log.WithName("controllers"),
+		Scheme:                     mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Cluster")
 		os.Exit(1)
 	}
+	if err = (&controllers.EncryptionPolicyReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("EncryptionPolicy"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "EncryptionPolicy")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
+	if err = mgr.Add(&encryption.ArchivePurger{
+		CtrlClient:      mgr.GetClient(),
+		Logger:          ctrl.Log.WithName("archive-purger"),
+		RetentionPeriod: archiveRetentionPeriod,
+	}); err != nil {
+		setupLog.Error(err, "unable to add encryption key archive purger")
+		os.Exit(1)
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -119,3 +269,76 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// restore rebuilds the encryption provider config secret of a cluster from escrowed or archived keys,
+// e.g. after the management cluster was rebuilt
+func restore(args []string) int {
+	var c encryption.RestoreConfig
+	var encryptedResources string
+	var vaultConfig encryption.VaultConfig
+	var dryRun bool
+
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.StringVar(&c.Cluster.Namespace, "namespace", "", "The namespace of the cluster.")
+	fs.StringVar(&c.Cluster.Name, "cluster", "", "The name of the cluster.")
+	fs.StringVar(&c.From, "from", "", "The source of the keys, escrow or the name of an archive secret of the cluster in its namespace.")
+	fs.StringVar(&encryptedResources, "encrypted-resources", encryption.DefaultEncryptedResource, "Comma separated list of resources encrypted if the keys are restored from escrow.")
+	fs.StringVar(&vaultConfig.Address, "key-escrow-vault-address", "", "The address of the Vault server the keys are escrowed to.")
+	fs.StringVar(&vaultConfig.TokenFile, "key-escrow-vault-token-file", "", "The file with the Vault token. The token is read from VAULT_TOKEN if empty.")
+	fs.StringVar(&vaultConfig.Mount, "key-escrow-vault-mount", encryption.DefaultVaultMount, "The mount path of the Vault KV v2 secrets engine keys are escrowed to.")
+	fs.StringVar(&vaultConfig.PathPrefix, "key-escrow-vault-path-prefix", encryption.DefaultVaultPathPrefix, "The path in the Vault KV v2 secrets engine below which the keys are escrowed.")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the names of the restored keys without creating the secret.")
+	_ = fs.Parse(args)
+
+	ctrl.SetLogger(zap.New())
+
+	if c.Cluster.Namespace == "" || c.Cluster.Name == "" || c.From == "" {
+		setupLog.Error(fmt.Errorf("--namespace, --cluster and --from are required"), "invalid flag value")
+		return 1
+	}
+	c.Resources = strings.Split(encryptedResources, ",")
+	if err := encryption.ValidateResources(c.Resources); err != nil {
+		setupLog.Error(err, "invalid flag value")
+		return 1
+	}
+
+	if vaultConfig.Address != "" {
+		vaultConfig.Token = os.Getenv("VAULT_TOKEN")
+		vaultEscrow, err := encryption.NewVaultKeyEscrow(vaultConfig)
+		if err != nil {
+			setupLog.Error(err, "invalid key escrow configuration")
+			return 1
+		}
+		c.KeyEscrow = vaultEscrow
+	}
+
+	ctrlClient, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		return 1
+	}
+	c.CtrlClient = ctrlClient
+
+	ctx := ctrl.SetupSignalHandler()
+	if dryRun {
+		restored, err := encryption.LoadRestoredKeys(ctx, c)
+		if err != nil {
+			setupLog.Error(err, "unable to load keys")
+			return 1
+		}
+		fmt.Printf("restoring encryption config of cluster %s/%s from %s, source has encryption config: %t\n", c.Cluster.Namespace, c.Cluster.Name, c.From, len(restored.Config) > 0)
+		for _, k := range restored.Keys {
+			fmt.Printf("%s %s active from %s\n", k.Provider, k.Name, k.ActiveFrom.Format(time.RFC3339))
+		}
+		return 0
+	}
+
+	secret, err := encryption.Restore(ctx, c)
+	if err != nil {
+		setupLog.Error(err, "unable to restore encryption provider config secret")
+		return 1
+	}
+	setupLog.Info(fmt.Sprintf("restored encryption provider config secret %s/%s from %s", secret.Namespace, secret.Name, c.From))
+
+	return 0
+}
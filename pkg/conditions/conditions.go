@@ -0,0 +1,103 @@
+package conditions
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	capiconditions "sigs.k8s.io/cluster-api/util/deprecated/v1beta1/conditions"
+	v1beta2conditions "sigs.k8s.io/cluster-api/util/deprecated/v1beta1/conditions/v1beta2"
+)
+
+const (
+	// EncryptionConfigReady reports whether the encryption provider config secret of the cluster exists and is valid.
+	EncryptionConfigReady capi.ConditionType = "EncryptionConfigReady"
+	// EncryptionKeyRotationInProgress reports whether a key rotation is running, the reason is the current phase.
+	EncryptionKeyRotationInProgress capi.ConditionType = "EncryptionKeyRotationInProgress"
+	// EncryptionKeyRotationStale reports whether the current encryption key is older than the rotation period.
+	EncryptionKeyRotationStale capi.ConditionType = "EncryptionKeyRotationStale"
+	// EncryptionReconciliationPaused reports whether the operator only reports the status of the cluster.
+	EncryptionReconciliationPaused capi.ConditionType = "EncryptionReconciliationPaused"
+)
+
+const (
+	// EncryptionConfigAvailableReason is used when the encryption provider config secret exists.
+	EncryptionConfigAvailableReason = "EncryptionConfigAvailable"
+	// EncryptionConfigFailedReason is used when the encryption provider config secret can not be created or read.
+	EncryptionConfigFailedReason = "EncryptionConfigFailed"
+	// EncryptionConfigMissingReason is used when the encryption provider config secret of an initialized or paused cluster is missing.
+	EncryptionConfigMissingReason = "EncryptionConfigMissing"
+	// EncryptionConfigRestoreFailedReason is used when the encryption provider config secret can not be restored.
+	EncryptionConfigRestoreFailedReason = "EncryptionConfigRestoreFailed"
+	// InvalidEncryptionConfigReason is used when the encryption provider config secret can not be parsed.
+	InvalidEncryptionConfigReason = "InvalidEncryptionConfig"
+
+	// RotationNotInProgressReason is used when no key rotation is running.
+	RotationNotInProgressReason = "RotationNotInProgress"
+	// RotationDisabledReason is used when key rotation is not enabled for the cluster.
+	RotationDisabledReason = "RotationDisabled"
+	// RotationDeferredReason is used when a due key rotation waits for the maintenance window.
+	RotationDeferredReason = "RotationDeferred"
+	// RotationCancelledReason is used when the last key rotation was cancelled.
+	RotationCancelledReason = "RotationCancelled"
+	// RotationFailedReason is used when the last step of the key rotation failed.
+	RotationFailedReason = "RotationFailed"
+
+	// KeyWithinRotationPeriodReason is used when the current key is younger than the rotation period.
+	KeyWithinRotationPeriodReason = "KeyWithinRotationPeriod"
+	// KeyOlderThanRotationPeriodReason is used when the current key is older than the rotation period.
+	KeyOlderThanRotationPeriodReason = "KeyOlderThanRotationPeriod"
+	// KeyManagedByKMSReason is used when the keys are managed by a KMS plugin and not rotated by the operator.
+	KeyManagedByKMSReason = "KeyManagedByKMS"
+
+	// NotPausedReason is used when the cluster is reconciled.
+	NotPausedReason = "NotPaused"
+	// ClusterPausedReason is used when the cluster is paused by Cluster API with spec.paused or the paused annotation.
+	ClusterPausedReason = "ClusterPaused"
+	// EncryptionPausedReason is used when only the encryption reconciliation is paused with the operator annotation.
+	EncryptionPausedReason = "EncryptionPaused"
+)
+
+// Owned are the conditions set by the operator, they are patched on the cluster even if they changed in the meantime.
+var Owned = []capi.ConditionType{
+	EncryptionConfigReady,
+	EncryptionKeyRotationInProgress,
+	EncryptionKeyRotationStale,
+	EncryptionReconciliationPaused,
+}
+
+// OwnedV1Beta2 returns the types of the owned conditions for the v1beta2 conditions of the cluster.
+func OwnedV1Beta2() []string {
+	var owned []string
+	for _, t := range Owned {
+		owned = append(owned, string(t))
+	}
+
+	return owned
+}
+
+// MarkTrue sets the condition to True on both the v1beta1 and v1beta2 conditions of the cluster.
+func MarkTrue(cluster *capi.Cluster, t capi.ConditionType, reason string, messageFormat string, messageArgs ...interface{}) {
+	set(cluster, &capi.Condition{
+		Type:    t,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: fmt.Sprintf(messageFormat, messageArgs...),
+	})
+}
+
+// MarkFalse sets the condition to False on both the v1beta1 and v1beta2 conditions of the cluster.
+func MarkFalse(cluster *capi.Cluster, t capi.ConditionType, reason string, severity capi.ConditionSeverity, messageFormat string, messageArgs ...interface{}) {
+	set(cluster, capiconditions.FalseCondition(t, reason, severity, messageFormat, messageArgs...))
+}
+
+func set(cluster *capi.Cluster, condition *capi.Condition) {
+	capiconditions.Set(cluster, condition)
+	v1beta2conditions.Set(cluster, metav1.Condition{
+		Type:    string(condition.Type),
+		Status:  metav1.ConditionStatus(condition.Status),
+		Reason:  condition.Reason,
+		Message: condition.Message,
+	})
+}
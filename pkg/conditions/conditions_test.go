@@ -0,0 +1,59 @@
+package conditions
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	capiconditions "sigs.k8s.io/cluster-api/util/deprecated/v1beta1/conditions"
+)
+
+func Test_MarkTrue(t *testing.T) {
+	transitionTime := metav1.Unix(100, 0)
+	cluster := &capi.Cluster{
+		Status: capi.ClusterStatus{
+			Conditions: capi.Conditions{
+				{
+					Type:               EncryptionKeyRotationInProgress,
+					Status:             corev1.ConditionTrue,
+					Reason:             "RewritingSecrets",
+					Message:            "key rotation is in phase RewritingSecrets",
+					LastTransitionTime: transitionTime,
+				},
+			},
+		},
+	}
+
+	MarkTrue(cluster, EncryptionKeyRotationInProgress, "RewritingSecrets", "key rotation is in phase %s", "RewritingSecrets")
+	MarkTrue(cluster, EncryptionConfigReady, EncryptionConfigAvailableReason, "")
+
+	if len(cluster.Status.Conditions) != 2 {
+		t.Fatalf("expected 2 v1beta1 conditions but got %d", len(cluster.Status.Conditions))
+	}
+	rotation := capiconditions.Get(cluster, EncryptionKeyRotationInProgress)
+	if !rotation.LastTransitionTime.Equal(&transitionTime) {
+		t.Fatalf("expected transition time to be kept when condition does not change")
+	}
+
+	MarkFalse(cluster, EncryptionKeyRotationInProgress, RotationNotInProgressReason, capi.ConditionSeverityInfo, "")
+	rotation = capiconditions.Get(cluster, EncryptionKeyRotationInProgress)
+	if rotation.LastTransitionTime.Equal(&transitionTime) {
+		t.Fatalf("expected transition time to change with the status")
+	}
+	if rotation.Reason != RotationNotInProgressReason || rotation.Severity != capi.ConditionSeverityInfo {
+		t.Fatalf("expected condition to be updated but got reason %q and severity %q", rotation.Reason, rotation.Severity)
+	}
+
+	if capiconditions.IsTrue(cluster, EncryptionKeyRotationInProgress) || !capiconditions.IsTrue(cluster, EncryptionConfigReady) {
+		t.Fatalf("expected only condition %s to be True", EncryptionConfigReady)
+	}
+
+	if !meta.IsStatusConditionFalse(cluster.GetV1Beta2Conditions(), string(EncryptionKeyRotationInProgress)) {
+		t.Fatalf("expected v1beta2 condition %s to be False", EncryptionKeyRotationInProgress)
+	}
+	if !meta.IsStatusConditionTrue(cluster.GetV1Beta2Conditions(), string(EncryptionConfigReady)) {
+		t.Fatalf("expected v1beta2 condition %s to be True", EncryptionConfigReady)
+	}
+}
@@ -17,7 +17,7 @@ limitations under the License.
 */
 
 import (
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
 )
 
 // copied from https://github.com/kubernetes/apiserver/blob/v0.23.1/pkg/apis/config/types.go
@@ -84,14 +84,44 @@ type IdentityConfiguration struct{}
 
 // KMSConfiguration contains the name, cache size and path to configuration file for a KMS based envelope transformer.
 type KMSConfiguration struct {
+	// apiVersion of KeyManagementService
+	// +optional
+	APIVersion string `yaml:"apiVersion,omitempty"`
 	// name is the name of the KMS plugin to be used.
 	Name string `yaml:"name"`
-	// cacheSize is the maximum number of secrets which are cached in memory. The default value is 1000.
+	// cachesize is the maximum number of secrets which are cached in memory. The default value is 1000.
+	// Set to a negative value to disable caching. This field is only allowed for KMS v1 providers.
 	// +optional
-	CacheSize int32 `yaml:"cache_size"`
+	CacheSize *int32 `yaml:"cachesize,omitempty"`
 	// endpoint is the gRPC server listening address, for example "unix:///var/run/kms-provider.sock".
 	Endpoint string `yaml:"endpoint"`
-	// Timeout for gRPC calls to kms-plugin (ex. 5s). The default is 3 seconds.
+	// timeout for gRPC calls to kms-plugin (ex. 5s). The default is 3 seconds.
 	// +optional
-	Timeout *metav1.Duration `yaml:"timeout,omitempty"`
+	Timeout *Duration `yaml:"timeout,omitempty"`
+}
+
+// Duration is a time.Duration which is marshaled to yaml in the same format as metav1.Duration is marshaled to json.
+type Duration struct {
+	time.Duration
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.Duration.String(), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	err := unmarshal(&str)
+	if err != nil {
+		return err
+	}
+
+	pd, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	d.Duration = pd
+	return nil
 }
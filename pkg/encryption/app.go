@@ -10,11 +10,13 @@ import (
 	"github.com/giantswarm/microerror"
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/giantswarm/encryption-provider-operator/pkg/project"
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
 )
 
 const (
@@ -62,19 +64,23 @@ func (s *Service) deployEncryptionProviderHasherApp(ctx context.Context, wcClien
 		if err != nil {
 			return microerror.Mask(err)
 		}
+		changed := !equality.Semantic.DeepEqual(chart.Spec, chartSpec(s.appCatalog))
 		chart.Spec = chartSpec(s.appCatalog)
 
 		err = wcClient.Update(ctx, chart)
 		if err != nil {
 			return microerror.Mask(err)
-		} else {
+		} else if changed {
+			// the app is updated on every reconciliation while waiting for the control plane, only report actual changes
 			s.logger.Info(fmt.Sprintf("updated '%s' app in workload cluster", chart.Name))
+			record.Eventf(s.cluster, "EncryptionConfigHasherUpdated", "Updated %s app in the workload cluster", chart.Name)
 		}
 
 	} else if err != nil {
 		return microerror.Mask(err)
 	} else {
 		s.logger.Info(fmt.Sprintf("deployed '%s' app to workload cluster", chart.Name))
+		record.Eventf(s.cluster, "EncryptionConfigHasherDeployed", "Deployed %s app to the workload cluster", chart.Name)
 	}
 
 	return nil
@@ -0,0 +1,195 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
+)
+
+const (
+	// ArchiveLabel marks the secrets holding the archived key material of deleted clusters.
+	ArchiveLabel = "encryption.giantswarm.io/archive"
+	// ArchivedAtAnnotation is the time the key material was archived, the archive is purged after the retention period.
+	ArchivedAtAnnotation = "encryption.giantswarm.io/archived-at"
+	// ArchivedClusterUIDAnnotation is the UID of the deleted cluster, so a retried archive does not archive the cluster twice.
+	ArchivedClusterUIDAnnotation = "encryption.giantswarm.io/archived-cluster-uid"
+
+	// LegacyEncryptionKey is the data key of the archived key of the legacy product.
+	LegacyEncryptionKey = "legacy-encryption"
+
+	// DefaultArchivePurgeInterval is the interval the archives are checked for expiry.
+	DefaultArchivePurgeInterval = time.Hour
+)
+
+// archiveKeyMaterial moves all key material of the deleted cluster into a dated archive secret and deletes the given secrets,
+// the keys of the legacy product are archived too, so every etcd backup of the cluster can still be decrypted
+func (s *Service) archiveKeyMaterial(ctx context.Context, secrets []*v1.Secret) error {
+	archive, err := s.existingArchive(ctx)
+	if err != nil {
+		return microerror.Mask(err)
+	} else if archive == nil {
+		archive, err = s.createArchive(ctx, secrets)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	for _, secret := range secrets {
+		err = s.ctrlClient.Delete(ctx, secret)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return microerror.Mask(err)
+		}
+	}
+
+	s.logger.Info(fmt.Sprintf("archived key material of deleted cluster to %s", archive.Name))
+	record.Eventf(s.cluster, "EncryptionKeyArchived", "Archived key material of the deleted cluster to %s", archive.Name)
+
+	return nil
+}
+
+// existingArchive returns the archive of the deleted cluster if the key material was already archived,
+// e.g. when deleting the archived secrets failed before
+func (s *Service) existingArchive(ctx context.Context) (*v1.Secret, error) {
+	var archives v1.SecretList
+	err := s.ctrlClient.List(ctx, &archives, ctrlclient.InNamespace(s.cluster.Namespace), ctrlclient.MatchingLabels{ArchiveLabel: "true", capi.ClusterNameLabel: s.cluster.Name})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	for i := range archives.Items {
+		if archives.Items[i].Annotations[ArchivedClusterUIDAnnotation] == string(s.cluster.UID) {
+			return &archives.Items[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// createArchive creates the dated archive secret with the key material of the given secrets and the legacy key
+func (s *Service) createArchive(ctx context.Context, secrets []*v1.Secret) (*v1.Secret, error) {
+	archivedAt := time.Now().UTC()
+
+	labels := map[string]string{}
+	data := map[string][]byte{}
+	for _, secret := range secrets {
+		maps.Copy(labels, secret.Labels)
+		maps.Copy(data, secret.Data)
+	}
+	labels[ArchiveLabel] = "true"
+	labels[capi.ClusterNameLabel] = s.cluster.Name
+
+	archive := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.ArchiveSecretName(s.cluster.Name, archivedAt),
+			Namespace: s.cluster.Namespace,
+			Labels:    labels,
+			Annotations: map[string]string{
+				ArchivedAtAnnotation:         archivedAt.Format(time.RFC3339),
+				ArchivedClusterUIDAnnotation: string(s.cluster.UID),
+			},
+		},
+		Data: data,
+	}
+
+	var legacySecret v1.Secret
+	err := s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{Name: key.LegacySecretName(s.cluster.Name), Namespace: s.cluster.Namespace}, &legacySecret)
+	if apierrors.IsNotFound(err) {
+		// cluster was never running the legacy product
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	} else if k, ok := legacySecret.Data["encryption"]; ok {
+		archive.Data[LegacyEncryptionKey] = k
+	}
+
+	err = s.ctrlClient.Create(ctx, archive)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, microerror.Mask(err)
+	}
+
+	return archive, nil
+}
+
+// ArchivePurger deletes the archived key material of deleted clusters once the retention period passed.
+type ArchivePurger struct {
+	CtrlClient ctrlclient.Client
+	Logger     logr.Logger
+	// RetentionPeriod is the time archives are kept, archives are never purged if it is zero.
+	RetentionPeriod time.Duration
+	// Interval is the time between two purges, defaults to DefaultArchivePurgeInterval.
+	Interval time.Duration
+}
+
+// Start purges the expired archives periodically until the context is done, it implements manager.Runnable
+func (p *ArchivePurger) Start(ctx context.Context) error {
+	if p.RetentionPeriod <= 0 {
+		return nil
+	}
+
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultArchivePurgeInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := p.Purge(ctx, time.Now())
+		if err != nil {
+			p.Logger.Error(err, "failed to purge expired encryption key archives")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection returns true, so only the leader purges archives
+func (p *ArchivePurger) NeedLeaderElection() bool {
+	return true
+}
+
+// Purge deletes all archives which were archived longer than the retention period before now
+func (p *ArchivePurger) Purge(ctx context.Context, now time.Time) error {
+	var archives v1.SecretList
+	err := p.CtrlClient.List(ctx, &archives, ctrlclient.MatchingLabels{ArchiveLabel: "true"})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	for i := range archives.Items {
+		archive := &archives.Items[i]
+
+		archivedAt, err := time.Parse(time.RFC3339, archive.Annotations[ArchivedAtAnnotation])
+		if err != nil {
+			// never purge key material without a known archive time
+			p.Logger.Info(fmt.Sprintf("skipping encryption key archive %s/%s without valid %s annotation", archive.Namespace, archive.Name, ArchivedAtAnnotation))
+			continue
+		}
+		if now.Sub(archivedAt) < p.RetentionPeriod {
+			continue
+		}
+
+		err = p.CtrlClient.Delete(ctx, archive)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return microerror.Mask(err)
+		}
+		p.Logger.Info(fmt.Sprintf("purged encryption key archive %s/%s archived at %s", archive.Namespace, archive.Name, archivedAt.Format(time.RFC3339)))
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package encryption
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+)
+
+func Test_archiveKeyMaterial(t *testing.T) {
+	ctx := context.Background()
+	secret := v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.SecretName("abc12"),
+			Namespace: "org-test",
+			Labels:    map[string]string{capi.ClusterNameLabel: "abc12"},
+		},
+		Data: map[string][]byte{EncryptionProviderConfig: []byte("config")},
+	}
+	historySecret := v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: key.KeyHistorySecretName("abc12"), Namespace: "org-test"},
+		Data:       map[string][]byte{KeyHistory: []byte(`[{"name":"key1","provider":"aescbc","secret":"old"}]`)},
+	}
+	legacySecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: key.LegacySecretName("abc12"), Namespace: "org-test"},
+		Data:       map[string][]byte{"encryption": []byte("legacy-key")},
+	}
+
+	s := &Service{
+		cluster:    &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "abc12", Namespace: "org-test"}},
+		ctrlClient: fake.NewClientBuilder().WithObjects(secret.DeepCopy(), historySecret.DeepCopy(), legacySecret).Build(),
+		logger:     logr.Discard(),
+	}
+
+	err := s.archiveKeyMaterial(ctx, []*v1.Secret{&secret, &historySecret})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	for _, archived := range []*v1.Secret{&secret, &historySecret} {
+		err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKeyFromObject(archived), &v1.Secret{})
+		if !apierrors.IsNotFound(err) {
+			t.Fatalf("expected secret %s to be deleted but got %v", archived.Name, err)
+		}
+	}
+
+	var archives v1.SecretList
+	err = s.ctrlClient.List(ctx, &archives, ctrlclient.MatchingLabels{ArchiveLabel: "true", capi.ClusterNameLabel: "abc12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives.Items) != 1 {
+		t.Fatalf("expected 1 archive but got %d", len(archives.Items))
+	}
+	archive := archives.Items[0]
+	if string(archive.Data[EncryptionProviderConfig]) != "config" || string(archive.Data[LegacyEncryptionKey]) != "legacy-key" || len(archive.Data[KeyHistory]) == 0 {
+		t.Fatalf("expected current, historic and legacy key material in archive but got %v", archive.Data)
+	}
+	if _, err := time.Parse(time.RFC3339, archive.Annotations[ArchivedAtAnnotation]); err != nil {
+		t.Fatalf("expected archive time annotation but got %q", archive.Annotations[ArchivedAtAnnotation])
+	}
+}
+
+func Test_archiveKeyMaterial_retry(t *testing.T) {
+	ctx := context.Background()
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.SecretName("abc12"),
+			Namespace: "org-test",
+			Labels:    map[string]string{capi.ClusterNameLabel: "abc12"},
+		},
+		Data: map[string][]byte{EncryptionProviderConfig: []byte("config")},
+	}
+	newArchive := func(archivedAt time.Time, clusterUID string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        key.ArchiveSecretName("abc12", archivedAt),
+				Namespace:   "org-test",
+				Labels:      map[string]string{ArchiveLabel: "true", capi.ClusterNameLabel: "abc12"},
+				Annotations: map[string]string{ArchivedClusterUIDAnnotation: clusterUID},
+			},
+		}
+	}
+	// the archive of a previous cluster with the same name must not be reused
+	previousClusterArchive := newArchive(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), "old-uid")
+	archive := newArchive(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), "uid")
+
+	s := &Service{
+		cluster:    &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "abc12", Namespace: "org-test", UID: "uid"}},
+		ctrlClient: fake.NewClientBuilder().WithObjects(secret, previousClusterArchive, archive).Build(),
+		logger:     logr.Discard(),
+	}
+
+	err := s.archiveKeyMaterial(ctx, []*v1.Secret{secret})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKeyFromObject(secret), &v1.Secret{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected secret %s to be deleted but got %v", secret.Name, err)
+	}
+
+	var archives v1.SecretList
+	err = s.ctrlClient.List(ctx, &archives, ctrlclient.MatchingLabels{ArchiveLabel: "true", capi.ClusterNameLabel: "abc12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives.Items) != 2 {
+		t.Fatalf("expected the existing archive to be reused but got %d archives", len(archives.Items))
+	}
+}
+
+func Test_ArchivePurger_Purge(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	newArchive := func(name string, archivedAt string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "org-test",
+				Labels:      map[string]string{ArchiveLabel: "true"},
+				Annotations: map[string]string{ArchivedAtAnnotation: archivedAt},
+			},
+		}
+	}
+	expired := newArchive("expired", now.Add(-31*24*time.Hour).Format(time.RFC3339))
+	recent := newArchive("recent", now.Add(-24*time.Hour).Format(time.RFC3339))
+	unknown := newArchive("unknown", "")
+
+	p := &ArchivePurger{
+		CtrlClient:      fake.NewClientBuilder().WithObjects(expired, recent, unknown).Build(),
+		Logger:          logr.Discard(),
+		RetentionPeriod: 30 * 24 * time.Hour,
+	}
+
+	err := p.Purge(ctx, now)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	for _, tc := range []struct {
+		archive *v1.Secret
+		purged  bool
+	}{
+		{archive: expired, purged: true},
+		{archive: recent},
+		{archive: unknown},
+	} {
+		err = p.CtrlClient.Get(ctx, ctrlclient.ObjectKeyFromObject(tc.archive), &v1.Secret{})
+		if tc.purged && !apierrors.IsNotFound(err) {
+			t.Fatalf("expected archive %s to be purged but got %v", tc.archive.Name, err)
+		} else if !tc.purged && err != nil {
+			t.Fatalf("expected archive %s to be kept but got %s", tc.archive.Name, err)
+		}
+	}
+}
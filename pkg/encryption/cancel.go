@@ -0,0 +1,190 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	"github.com/giantswarm/microerror"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	configv1 "github.com/giantswarm/encryption-provider-operator/pkg/config"
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
+)
+
+// addedKey returns the provider and the name of the key the rotation added to the config,
+// both are empty if the config already had a new key which was not pruned yet
+func addedKey(oldSecret v1.Secret, newSecret v1.Secret) (string, string, error) {
+	current, err := currentProvider(newSecret)
+	if err != nil {
+		return "", "", microerror.Mask(err)
+	}
+
+	if current.KMS != nil {
+		previous, err := currentProvider(oldSecret)
+		if err != nil {
+			return "", "", microerror.Mask(err)
+		}
+		if previous.KMS != nil && previous.KMS.Name == current.KMS.Name && previous.KMS.Endpoint == current.KMS.Endpoint {
+			return "", "", nil
+		}
+		return providerType(current), current.KMS.Name, nil
+	}
+
+	keys := providerKeys(current)
+	if keys == nil || len(*keys) == 0 {
+		return "", "", nil
+	}
+	k := HistoricKey{Name: (*keys)[0].Name, Provider: providerType(current), Secret: (*keys)[0].Secret}
+
+	oldKeys, err := configKeys(oldSecret)
+	if err != nil {
+		return "", "", microerror.Mask(err)
+	}
+	if len(appendHistoricKey(oldKeys, k)) == len(oldKeys) {
+		return "", "", nil
+	}
+
+	return k.Provider, k.Name, nil
+}
+
+// removeNewEncryptionKey is the reverse of addNewEncryptionKey, it removes the key added by the rotation from the first provider,
+// the provider is removed if it has no other key, so the previous provider encrypts new data again
+func removeNewEncryptionKey(secret *v1.Secret, provider string, name string) error {
+	var ec configv1.EncryptionConfiguration
+	err := yaml.Unmarshal(secret.Data[EncryptionProviderConfig], &ec)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	for i := range ec.Resources {
+		if isDecryptingResourceConfiguration(ec.Resources[i]) {
+			continue
+		}
+
+		providers := ec.Resources[i].Providers
+		if len(providers) == 0 || providerType(providers[0]) != provider {
+			return microerror.Mask(fmt.Errorf("new %s key %s is not used to encrypt resources %v", provider, name, ec.Resources[i].Resources))
+		}
+
+		if providers[0].KMS != nil {
+			if providers[0].KMS.Name != name {
+				return microerror.Mask(fmt.Errorf("new kms provider %s is not used to encrypt resources %v", name, ec.Resources[i].Resources))
+			}
+			providers = providers[1:]
+		} else {
+			keys := providerKeys(providers[0])
+			if keys == nil || len(*keys) == 0 || (*keys)[0].Name != name {
+				return microerror.Mask(fmt.Errorf("new %s key %s is not used to encrypt resources %v", provider, name, ec.Resources[i].Resources))
+			}
+			if len(*keys) > 1 {
+				*keys = (*keys)[1:]
+			} else {
+				providers = providers[1:]
+			}
+		}
+
+		if len(providers) == 0 || providerType(providers[0]) == providerIdentity {
+			// the resources would be written unencrypted
+			return microerror.Mask(fmt.Errorf("encryption config has no previous key for resources %v to revert to", ec.Resources[i].Resources))
+		}
+		ec.Resources[i].Providers = providers
+	}
+
+	o, err := yaml.Marshal(ec)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	secret.Data[EncryptionProviderConfig] = o
+	return nil
+}
+
+// cancelRotation handles the cancel annotation, the new key is removed and the rotation moves to the RevertingKey phase
+// if the resources were not rewritten with the new key yet and the rotation did not change the encrypted resources,
+// the annotation is always removed and a rejected cancellation is reported in the status message
+func (s *Service) cancelRotation(ctx context.Context, secret *v1.Secret, status *v1alpha1.RotationStatus) error {
+	delete(secret.Annotations, v1alpha1.CancelRotationAnnotation)
+
+	phase := status.Phase
+	if phase == v1alpha1.RotationPhaseFailed {
+		phase = status.FailedPhase
+	}
+
+	switch {
+	case !status.Phase.InProgress() || phase == v1alpha1.RotationPhaseRevertingKey:
+		s.logger.Info("no key rotation to cancel")
+		err := s.ctrlClient.Update(ctx, secret)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		return nil
+
+	case !phase.Cancellable() || status.NewKeyName == "" || status.ResourcesChanged:
+		reason := fmt.Sprintf("key rotation can not be cancelled in phase %s, the resources are rewritten with the new key", phase)
+		if status.NewKeyName == "" {
+			reason = "key rotation can not be cancelled, the key added by the rotation is unknown"
+		} else if status.ResourcesChanged {
+			reason = "key rotation can not be cancelled, it changed the encrypted resources"
+		}
+		s.logger.Info(reason)
+		record.Warnf(s.cluster, "EncryptionKeyRotationCancelRejected", "Rejected cancelling the key rotation of encryption provider config secret %s: %s", secret.Name, reason)
+		status.Message = reason
+		return s.updateRotationStatus(ctx, secret, status)
+	}
+
+	err := removeNewEncryptionKey(secret, status.NewKeyProvider, status.NewKeyName)
+	if err != nil {
+		// the config in memory is unchanged, only the annotation removal and the reason are persisted
+		s.logger.Error(err, "failed to remove new encryption key from the config")
+		record.Warnf(s.cluster, "EncryptionKeyRotationCancelRejected", "Rejected cancelling the key rotation of encryption provider config secret %s: %s", secret.Name, err)
+		status.Message = fmt.Sprintf("key rotation can not be cancelled: %s", err)
+		return s.updateRotationStatus(ctx, secret, status)
+	}
+
+	status.SetPhase(v1alpha1.RotationPhaseRevertingKey, metav1.Now())
+	status.Message = fmt.Sprintf("key rotation was cancelled in phase %s", phase)
+	err = s.updateRotationStatus(ctx, secret, status)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	s.logger.Info(fmt.Sprintf("cancelled key rotation in phase %s, removed new %s key %s from the config", phase, status.NewKeyProvider, status.NewKeyName))
+	record.Eventf(s.cluster, "EncryptionKeyReverted", "Cancelled key rotation in phase %s and removed new %s key %s from encryption provider config secret %s", phase, status.NewKeyProvider, status.NewKeyName, secret.Name)
+
+	return nil
+}
+
+// rotationRevertingKey waits until all control plane nodes use the config without the new key and removes the hasher app,
+// the control plane is rolled out if enabled
+func (s *Service) rotationRevertingKey(ctx context.Context, wcClient ctrlclient.Client, secret *v1.Secret, status *v1alpha1.RotationStatus) (v1alpha1.RotationPhase, error) {
+	if s.controlPlaneRollout {
+		triggered, err := s.rolloutControlPlane(ctx, status, v1alpha1.RotationPhaseRevertingKey)
+		if err != nil {
+			return v1alpha1.RotationPhaseRevertingKey, microerror.Mask(err)
+		} else if triggered {
+			// the control plane provider has not seen the rollout yet, check again in next reconciliation loop
+			return v1alpha1.RotationPhaseRevertingKey, nil
+		}
+	}
+
+	converged, err := s.waitForControlPlane(ctx, wcClient, secret, status)
+	if err != nil {
+		return v1alpha1.RotationPhaseRevertingKey, microerror.Mask(err)
+	} else if !converged {
+		return v1alpha1.RotationPhaseRevertingKey, nil
+	}
+
+	err = s.deleteEncryptionProviderHasherApp(ctx, wcClient)
+	if err != nil {
+		s.logger.Error(err, "failed to delete encryption-config-hasher app from workload cluster")
+		return v1alpha1.RotationPhaseRevertingKey, microerror.Mask(err)
+	}
+	s.logger.Info("all control plane nodes use the encryption config without the new key, key rotation cancelled")
+
+	delete(secret.Annotations, annotation.EncryptionRotationInProgress)
+
+	return v1alpha1.RotationPhaseCancelled, nil
+}
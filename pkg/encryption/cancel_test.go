@@ -0,0 +1,234 @@
+package encryption
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+)
+
+func Test_addedKey(t *testing.T) {
+	testCases := []struct {
+		name         string
+		oldSecret    v1.Secret
+		newSecret    v1.Secret
+		expectedName string
+	}{
+		{
+			name:         "case 0: key added",
+			oldSecret:    testSecretboxConfig("key1"),
+			newSecret:    testSecretboxConfig("key2", "key1"),
+			expectedName: "key2",
+		},
+		{
+			name:      "case 1: new key of previous rotation was not pruned",
+			oldSecret: testSecretboxConfig("key2", "key1"),
+			newSecret: testSecretboxConfig("key2", "key1"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			provider, name, err := addedKey(tc.oldSecret, tc.newSecret)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if name != tc.expectedName {
+				t.Fatalf("expected added key %q but got %q", tc.expectedName, name)
+			}
+			if name != "" && provider != string(v1alpha1.ProviderSecretbox) {
+				t.Fatalf("expected provider %s but got %s", string(v1alpha1.ProviderSecretbox), provider)
+			}
+		})
+	}
+}
+
+func Test_removeNewEncryptionKey(t *testing.T) {
+	testCases := []struct {
+		name         string
+		secret       v1.Secret
+		provider     string
+		keyName      string
+		expectedKeys []string
+		errorMatcher string
+	}{
+		{
+			name:         "case 0: remove new key",
+			secret:       testSecretboxConfig("key2", "key1"),
+			provider:     string(v1alpha1.ProviderSecretbox),
+			keyName:      "key2",
+			expectedKeys: []string{"key1"},
+		},
+		{
+			name:         "case 1: new key is not the first key",
+			secret:       testSecretboxConfig("key3", "key2"),
+			provider:     string(v1alpha1.ProviderSecretbox),
+			keyName:      "key2",
+			errorMatcher: "is not used to encrypt",
+		},
+		{
+			name:         "case 2: new key of another provider",
+			secret:       testSecretboxConfig("key2", "key1"),
+			provider:     string(v1alpha1.ProviderAESGCM),
+			keyName:      "key2",
+			errorMatcher: "is not used to encrypt",
+		},
+		{
+			name:         "case 3: no previous key",
+			secret:       testSecretboxConfig("key1"),
+			provider:     string(v1alpha1.ProviderSecretbox),
+			keyName:      "key1",
+			errorMatcher: "no previous key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := removeNewEncryptionKey(&tc.secret, tc.provider, tc.keyName)
+			if tc.errorMatcher != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errorMatcher) {
+					t.Fatalf("expected error matching %q but got %v", tc.errorMatcher, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			keys, err := configKeys(tc.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, k := range keys {
+				names = append(names, k.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tc.expectedKeys, ",") {
+				t.Fatalf("expected keys %v but got %v", tc.expectedKeys, names)
+			}
+		})
+	}
+}
+
+func Test_cancelRotation(t *testing.T) {
+	testCases := []struct {
+		name             string
+		phase            v1alpha1.RotationPhase
+		failedPhase      v1alpha1.RotationPhase
+		newKeyName       string
+		resourcesChanged bool
+		expectedPhase    v1alpha1.RotationPhase
+		expectedKeys     int
+		expectedMessage  string
+		expectedEvents   []string
+	}{
+		{
+			name:           "case 0: cancel while waiting for the control plane",
+			phase:          v1alpha1.RotationPhaseWaitingForControlPlane,
+			expectedPhase:  v1alpha1.RotationPhaseRevertingKey,
+			expectedKeys:   1,
+			expectedEvents: []string{"Normal EncryptionKeyReverted"},
+		},
+		{
+			name:           "case 1: cancel failed rotation in cancellable phase",
+			phase:          v1alpha1.RotationPhaseFailed,
+			failedPhase:    v1alpha1.RotationPhaseKeyAdded,
+			expectedPhase:  v1alpha1.RotationPhaseRevertingKey,
+			expectedKeys:   1,
+			expectedEvents: []string{"Normal EncryptionKeyReverted"},
+		},
+		{
+			name:            "case 2: resources are already rewritten",
+			phase:           v1alpha1.RotationPhaseRewritingSecrets,
+			expectedPhase:   v1alpha1.RotationPhaseRewritingSecrets,
+			expectedKeys:    2,
+			expectedMessage: "the resources are rewritten with the new key",
+			expectedEvents:  []string{"Warning EncryptionKeyRotationCancelRejected"},
+		},
+		{
+			name:             "case 3: rotation changed the encrypted resources",
+			phase:            v1alpha1.RotationPhaseKeyAdded,
+			resourcesChanged: true,
+			expectedPhase:    v1alpha1.RotationPhaseKeyAdded,
+			expectedKeys:     2,
+			expectedMessage:  "changed the encrypted resources",
+			expectedEvents:   []string{"Warning EncryptionKeyRotationCancelRejected"},
+		},
+		{
+			name:            "case 4: new key is not the current key",
+			phase:           v1alpha1.RotationPhaseWaitingForControlPlane,
+			newKeyName:      "key1",
+			expectedPhase:   v1alpha1.RotationPhaseWaitingForControlPlane,
+			expectedKeys:    2,
+			expectedMessage: "is not used to encrypt",
+			expectedEvents:  []string{"Warning EncryptionKeyRotationCancelRejected"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			secret := testSecretboxConfig("key2", "key1")
+			secret.ObjectMeta = metav1.ObjectMeta{
+				Name:        "test-encryption-provider-config",
+				Namespace:   "org-test",
+				Annotations: map[string]string{v1alpha1.CancelRotationAnnotation: "true"},
+			}
+			s := &Service{
+				cluster:    &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"}},
+				ctrlClient: fake.NewClientBuilder().WithObjects(&secret).Build(),
+				logger:     logr.Discard(),
+			}
+			newKeyName := tc.newKeyName
+			if newKeyName == "" {
+				newKeyName = "key2"
+			}
+			status := &v1alpha1.RotationStatus{
+				Phase:            tc.phase,
+				FailedPhase:      tc.failedPhase,
+				NewKeyProvider:   string(v1alpha1.ProviderSecretbox),
+				NewKeyName:       newKeyName,
+				ResourcesChanged: tc.resourcesChanged,
+			}
+
+			recordedEvents()
+			err := s.cancelRotation(ctx, &secret, status)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if status.Phase != tc.expectedPhase {
+				t.Fatalf("expected phase %s but got %s", tc.expectedPhase, status.Phase)
+			}
+			if !strings.Contains(status.Message, tc.expectedMessage) {
+				t.Fatalf("expected message matching %q but got %q", tc.expectedMessage, status.Message)
+			}
+
+			var updated v1.Secret
+			err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKeyFromObject(&secret), &updated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := updated.Annotations[v1alpha1.CancelRotationAnnotation]; ok {
+				t.Fatalf("expected cancel annotation to be removed")
+			}
+			keys, err := configKeys(updated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != tc.expectedKeys {
+				t.Fatalf("expected %d keys but got %d", tc.expectedKeys, len(keys))
+			}
+			if events := recordedEvents(); !reflect.DeepEqual(events, tc.expectedEvents) {
+				t.Fatalf("expected events %v but got %v", tc.expectedEvents, events)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// controlPlane is the observed state of the control plane provider object referenced by the cluster,
+// e.g. a KubeadmControlPlane
+type controlPlane struct {
+	// Generation is the generation of the spec.
+	Generation int64
+	// ObservedGeneration is the generation observed by the control plane provider, -1 if the provider does not report it.
+	ObservedGeneration int64
+	// Replicas is the desired number of control plane machines.
+	Replicas int64
+	// StatusReplicas is the current number of control plane machines.
+	StatusReplicas int64
+	// UpToDateReplicas is the number of control plane machines matching the desired spec,
+	// -1 if the provider does not report it.
+	UpToDateReplicas int64
+}
+
+// getControlPlane returns the control plane referenced by the cluster, nil if the cluster has no control plane reference
+// or the control plane does not report its replicas, e.g. for managed control planes without nodes
+func (s *Service) getControlPlane(ctx context.Context) (*controlPlane, error) {
+	ref := s.cluster.Spec.ControlPlaneRef
+	if ref == nil {
+		return nil, nil
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind))
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = s.cluster.Namespace
+	}
+	err := s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{Name: ref.Name, Namespace: namespace}, obj)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return controlPlaneFromUnstructured(obj)
+}
+
+func controlPlaneFromUnstructured(obj *unstructured.Unstructured) (*controlPlane, error) {
+	replicas, found, err := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if err != nil {
+		return nil, microerror.Mask(err)
+	} else if !found {
+		return nil, nil
+	}
+
+	cp := &controlPlane{Generation: obj.GetGeneration(), ObservedGeneration: -1, Replicas: replicas, UpToDateReplicas: -1}
+	cp.StatusReplicas, _, err = unstructured.NestedInt64(obj.Object, "status", "replicas")
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	observedGeneration, found, err := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if err != nil {
+		return nil, microerror.Mask(err)
+	} else if found {
+		cp.ObservedGeneration = observedGeneration
+	}
+
+	// v1beta2 control planes report upToDateReplicas, v1beta1 control planes report updatedReplicas
+	// and the v1beta2 field in status.v1beta2
+	for _, fields := range [][]string{
+		{"status", "upToDateReplicas"},
+		{"status", "v1beta2", "upToDateReplicas"},
+		{"status", "updatedReplicas"},
+	} {
+		upToDate, found, err := unstructured.NestedInt64(obj.Object, fields...)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		} else if found {
+			cp.UpToDateReplicas = upToDate
+			break
+		}
+	}
+
+	return cp, nil
+}
+
+// rolloutInProgress returns a reason if the control plane is scaling or rolling out new machines
+func (cp *controlPlane) rolloutInProgress() string {
+	if cp.ObservedGeneration >= 0 && cp.ObservedGeneration < cp.Generation {
+		return "control plane has not observed its latest spec yet"
+	}
+	if cp.StatusReplicas != cp.Replicas {
+		return fmt.Sprintf("control plane is scaling from %d to %d replicas", cp.StatusReplicas, cp.Replicas)
+	}
+	if cp.UpToDateReplicas >= 0 && cp.UpToDateReplicas < cp.Replicas {
+		return fmt.Sprintf("control plane rollout is in progress, %d of %d replicas are up to date", cp.UpToDateReplicas, cp.Replicas)
+	}
+
+	return ""
+}
+
+// expectedControlPlaneNodes returns the expected number of control plane nodes or a reason why the nodes are not checked yet,
+// without a control plane reference only 1, 3 or 5 nodes are expected
+func (s *Service) expectedControlPlaneNodes(ctx context.Context, nodeCount int) (int, string, error) {
+	cp, err := s.getControlPlane(ctx)
+	if err != nil {
+		return 0, "", microerror.Mask(err)
+	}
+
+	if cp == nil {
+		if nodeCount != 1 && nodeCount != 3 && nodeCount != 5 {
+			return 0, fmt.Sprintf("expected 1 or 3 or 5 control plane nodes but found %d, cluster is probably in transiting state", nodeCount), nil
+		}
+		return nodeCount, "", nil
+	}
+
+	if reason := cp.rolloutInProgress(); reason != "" {
+		return 0, reason, nil
+	}
+	if int64(nodeCount) != cp.Replicas {
+		return 0, fmt.Sprintf("found %d control plane nodes but the control plane has %d replicas", nodeCount, cp.Replicas), nil
+	}
+
+	return nodeCount, "", nil
+}
+
+// triggerControlPlaneRollout sets rolloutAfter on the control plane referenced by the cluster,
+// so all control plane machines created before the given time are replaced.
+// It returns false if rolloutAfter is already set to the given time.
+func (s *Service) triggerControlPlaneRollout(ctx context.Context, rolloutAfter metav1.Time) (bool, error) {
+	ref := s.cluster.Spec.ControlPlaneRef
+	if ref == nil {
+		return false, microerror.Mask(errors.New("cluster has no control plane reference to roll out"))
+	}
+
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gv.WithKind(ref.Kind))
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = s.cluster.Namespace
+	}
+	err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{Name: ref.Name, Namespace: namespace}, obj)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	// v1beta1 control planes have spec.rolloutAfter, later versions moved it to spec.rollout.after
+	fields := []string{"spec", "rollout", "after"}
+	if gv.Version == "v1beta1" {
+		fields = []string{"spec", "rolloutAfter"}
+	}
+	current, _, err := unstructured.NestedString(obj.Object, fields...)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+	value := rolloutAfter.UTC().Format(time.RFC3339)
+	if current == value {
+		return false, nil
+	}
+
+	patch := map[string]interface{}{}
+	err = unstructured.SetNestedField(patch, value, fields...)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+	encodedPatch, err := json.Marshal(patch)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+	err = s.ctrlClient.Patch(ctx, obj, ctrlclient.RawPatch(types.MergePatchType, encodedPatch))
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	return true, nil
+}
+
+// controlPlaneRolledOut returns true if the control plane referenced by the cluster finished rolling out its machines,
+// otherwise it returns the reason for waiting
+func (s *Service) controlPlaneRolledOut(ctx context.Context) (bool, string, error) {
+	cp, err := s.getControlPlane(ctx)
+	if err != nil {
+		return false, "", microerror.Mask(err)
+	} else if cp == nil {
+		return false, "", microerror.Mask(errors.New("cluster has no control plane with replicas to roll out"))
+	}
+
+	if reason := cp.rolloutInProgress(); reason != "" {
+		return false, reason, nil
+	}
+
+	return true, "", nil
+}
@@ -0,0 +1,291 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testKubeadmControlPlane(replicas, statusReplicas, updatedReplicas int64) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "controlplane.cluster.x-k8s.io/v1beta1",
+		"kind":       "KubeadmControlPlane",
+		"metadata": map[string]interface{}{
+			"name":      "test",
+			"namespace": "org-test",
+		},
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+		},
+		"status": map[string]interface{}{
+			"replicas":        statusReplicas,
+			"updatedReplicas": updatedReplicas,
+		},
+	}}
+
+	return obj
+}
+
+func testControlPlaneNode(name string, ready bool) *v1.Node {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				"node-role.kubernetes.io/master":        "",
+				"node-role.kubernetes.io/control-plane": "",
+			},
+		},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}}},
+	}
+}
+
+func Test_areAllMasterNodesUsingLatestConfig(t *testing.T) {
+	testCases := []struct {
+		name         string
+		controlPlane *unstructured.Unstructured
+		nodes        int
+		notReady     int
+		outdated     int
+		upToDate     bool
+		reason       string
+	}{
+		{
+			name:     "case 0: no control plane reference, 3 nodes up to date",
+			nodes:    3,
+			upToDate: true,
+		},
+		{
+			name:   "case 1: no control plane reference, 2 nodes",
+			nodes:  2,
+			reason: "expected 1 or 3 or 5 control plane nodes but found 2, cluster is probably in transiting state",
+		},
+		{
+			name:         "case 2: control plane with 2 replicas, 2 nodes up to date",
+			controlPlane: testKubeadmControlPlane(2, 2, 2),
+			nodes:        2,
+			upToDate:     true,
+		},
+		{
+			name:         "case 3: control plane is scaling",
+			controlPlane: testKubeadmControlPlane(3, 4, 3),
+			nodes:        4,
+			reason:       "control plane is scaling from 4 to 3 replicas",
+		},
+		{
+			name:         "case 4: control plane rollout in progress",
+			controlPlane: testKubeadmControlPlane(3, 3, 1),
+			nodes:        3,
+			reason:       "control plane rollout is in progress, 1 of 3 replicas are up to date",
+		},
+		{
+			name:         "case 5: node not joined yet",
+			controlPlane: testKubeadmControlPlane(3, 3, 3),
+			nodes:        2,
+			reason:       "found 2 control plane nodes but the control plane has 3 replicas",
+		},
+		{
+			name:         "case 6: node not ready",
+			controlPlane: testKubeadmControlPlane(3, 3, 3),
+			nodes:        3,
+			notReady:     1,
+			reason:       "2 of 3 control plane nodes are ready",
+		},
+		{
+			name:         "case 7: node with outdated config",
+			controlPlane: testKubeadmControlPlane(3, 3, 3),
+			nodes:        3,
+			outdated:     1,
+			reason:       "2 of 3 control plane nodes use the latest encryption provider config",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			cluster := &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"}}
+
+			mcClientBuilder := fake.NewClientBuilder()
+			if tc.controlPlane != nil {
+				cluster.Spec.ControlPlaneRef = &v1.ObjectReference{
+					APIVersion: tc.controlPlane.GetAPIVersion(),
+					Kind:       tc.controlPlane.GetKind(),
+					Name:       tc.controlPlane.GetName(),
+				}
+				mcClientBuilder = mcClientBuilder.WithObjects(tc.controlPlane)
+			}
+
+			shakeSecret := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      EncryptionProviderConfigShake256SecretName,
+					Namespace: EncryptionProviderConfigShake256SecretNamespace,
+				},
+				Data: map[string][]byte{},
+			}
+			objects := []ctrlclient.Object{shakeSecret}
+			for i := 0; i < tc.nodes; i++ {
+				sum := "latest"
+				if i < tc.outdated {
+					sum = "outdated"
+				}
+				node := testControlPlaneNode(fmt.Sprintf("master-%d", i), i >= tc.notReady)
+				shakeSecret.Data[node.Name] = []byte(sum)
+				objects = append(objects, node)
+			}
+			wcClient := fake.NewClientBuilder().WithObjects(objects...).Build()
+
+			s := &Service{
+				cluster:    cluster,
+				ctrlClient: mcClientBuilder.Build(),
+				logger:     logr.Discard(),
+			}
+
+			upToDate, reason, err := s.areAllMasterNodesUsingLatestConfig(ctx, wcClient, "latest")
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if upToDate != tc.upToDate {
+				t.Fatalf("expected up to date %t but got %t", tc.upToDate, upToDate)
+			}
+			if reason != tc.reason {
+				t.Fatalf("expected reason %q but got %q", tc.reason, reason)
+			}
+		})
+	}
+}
+
+func Test_triggerControlPlaneRollout(t *testing.T) {
+	testCases := []struct {
+		name       string
+		apiVersion string
+		fields     []string
+	}{
+		{
+			name:       "case 0: v1beta1 control plane",
+			apiVersion: "controlplane.cluster.x-k8s.io/v1beta1",
+			fields:     []string{"spec", "rolloutAfter"},
+		},
+		{
+			name:       "case 1: v1beta2 control plane",
+			apiVersion: "controlplane.cluster.x-k8s.io/v1beta2",
+			fields:     []string{"spec", "rollout", "after"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			kcp := testKubeadmControlPlane(3, 3, 3)
+			kcp.SetAPIVersion(tc.apiVersion)
+
+			s := &Service{
+				cluster: &capi.Cluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"},
+					Spec: capi.ClusterSpec{
+						ControlPlaneRef: &v1.ObjectReference{APIVersion: tc.apiVersion, Kind: kcp.GetKind(), Name: kcp.GetName()},
+					},
+				},
+				ctrlClient: fake.NewClientBuilder().WithObjects(kcp).Build(),
+				logger:     logr.Discard(),
+			}
+
+			rolloutAfter := metav1.Unix(1700000000, 0)
+			triggered, err := s.triggerControlPlaneRollout(ctx, rolloutAfter)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if !triggered {
+				t.Fatalf("expected rollout to be triggered")
+			}
+
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(kcp.GroupVersionKind())
+			err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKeyFromObject(kcp), obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, _, _ := unstructured.NestedString(obj.Object, tc.fields...)
+			if value != "2023-11-14T22:13:20Z" {
+				t.Fatalf("expected rolloutAfter 2023-11-14T22:13:20Z but got %q", value)
+			}
+
+			// setting the same time again does not trigger another rollout
+			triggered, err = s.triggerControlPlaneRollout(ctx, rolloutAfter)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if triggered {
+				t.Fatalf("expected rollout not to be triggered again")
+			}
+		})
+	}
+}
+
+func Test_controlPlaneRolledOut(t *testing.T) {
+	testCases := []struct {
+		name      string
+		kcp       func() *unstructured.Unstructured
+		rolledOut bool
+		reason    string
+	}{
+		{
+			name:      "case 0: rolled out",
+			kcp:       func() *unstructured.Unstructured { return testKubeadmControlPlane(3, 3, 3) },
+			rolledOut: true,
+		},
+		{
+			name: "case 1: rollout not observed yet",
+			kcp: func() *unstructured.Unstructured {
+				kcp := testKubeadmControlPlane(3, 3, 3)
+				kcp.SetGeneration(2)
+				_ = unstructured.SetNestedField(kcp.Object, int64(1), "status", "observedGeneration")
+				return kcp
+			},
+			reason: "control plane has not observed its latest spec yet",
+		},
+		{
+			name:   "case 2: rollout with surge machine",
+			kcp:    func() *unstructured.Unstructured { return testKubeadmControlPlane(3, 4, 1) },
+			reason: "control plane is scaling from 4 to 3 replicas",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kcp := tc.kcp()
+			s := &Service{
+				cluster: &capi.Cluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"},
+					Spec: capi.ClusterSpec{
+						ControlPlaneRef: &v1.ObjectReference{APIVersion: kcp.GetAPIVersion(), Kind: kcp.GetKind(), Name: kcp.GetName()},
+					},
+				},
+				ctrlClient: fake.NewClientBuilder().WithObjects(kcp).Build(),
+				logger:     logr.Discard(),
+			}
+
+			rolledOut, reason, err := s.controlPlaneRolledOut(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if rolledOut != tc.rolledOut {
+				t.Fatalf("expected rolled out %t but got %t", tc.rolledOut, rolledOut)
+			}
+			if reason != tc.reason {
+				t.Fatalf("expected reason %q but got %q", tc.reason, reason)
+			}
+		})
+	}
+}
@@ -22,15 +22,29 @@ import (
 	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/conditions"
 	configv1 "github.com/giantswarm/encryption-provider-operator/pkg/config"
+	"github.com/giantswarm/encryption-provider-operator/pkg/fleet"
 	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+	"github.com/giantswarm/encryption-provider-operator/pkg/metrics"
 	"github.com/giantswarm/encryption-provider-operator/pkg/project"
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
+	"github.com/giantswarm/encryption-provider-operator/pkg/remote"
 )
 
 const (
 	EncryptionProviderConfig = "encryption"
 	KeyNamePrefix            = "key"
 
+	// DefaultEncryptedResource is the kubernetes resource encrypted in etcd.
+	DefaultEncryptedResource = "secrets"
+
+	// DefaultRewritePageSize is the number of objects listed per request while rewriting the encrypted resources.
+	DefaultRewritePageSize = 500
+	// DefaultRewriteWorkers is the number of objects patched concurrently while rewriting the encrypted resources.
+	DefaultRewriteWorkers = 10
+
 	// Poly1305KeyLength represents the 32 bytes length for Poly1305
 	// padding encryption key.
 	Poly1305KeyLength = 32
@@ -40,20 +54,67 @@ const (
 )
 
 type Config struct {
-	AppCatalog               string
-	Cluster                  *capi.Cluster
+	AppCatalog string
+	Cluster    *capi.Cluster
+	// ClusterCache provides the clients of the workload clusters.
+	ClusterCache             *remote.ClusterCache
 	DefaultKeyRotationPeriod time.Duration
-	RegistryDomain           string
+	// DefaultProvider is the encryption provider used for clusters without a provider set in the EncryptionPolicy.
+	DefaultProvider v1alpha1.ProviderType
+	// DefaultResources are the resources encrypted for clusters without resources set in the EncryptionPolicy.
+	DefaultResources []string
+	// KMS is the KMS plugin used by clusters with the kms provider.
+	KMS KMSConfig
+	// Rewrite limits the load on the workload cluster while the encrypted resources are rewritten.
+	Rewrite RewriteConfig
+	// StorageVersionMigration enables re-encryption with StorageVersionMigrations if the workload cluster serves the API.
+	StorageVersionMigration bool
+	// ControlPlaneRollout enables rolling out the control plane after the encryption config changed.
+	ControlPlaneRollout bool
+	// OwnerReference makes the cluster the owner of the encryption provider config secret if the retention policy is Delete.
+	OwnerReference bool
+	// DefaultRetentionPolicy decides what happens to the key material of deleted clusters without a retention policy in the EncryptionPolicy.
+	DefaultRetentionPolicy v1alpha1.RetentionPolicy
+	// KeyEscrow stores every generated key outside of the management cluster, keys are only kept in secrets if it is nil.
+	KeyEscrow KeyEscrow
+	// MaintenanceWindow restricts when rotations start and resources are rewritten for clusters without
+	// a maintenance window in the EncryptionPolicy, rotations are not restricted if it is nil.
+	MaintenanceWindow *MaintenanceWindow
+	// MaxAESGCMKeyRotationPeriod caps the rotation period of clusters using the aesgcm provider.
+	MaxAESGCMKeyRotationPeriod time.Duration
+	// Fleet limits the number of concurrent rotations across all clusters and starts them in waves,
+	// rotations are not limited if it is nil.
+	Fleet *fleet.Coordinator
+	// Policy is the accepted EncryptionPolicy of the cluster, nil if the cluster has none.
+	Policy         *v1alpha1.EncryptionPolicy
+	RegistryDomain string
 
 	CtrlClient ctrlclient.Client
 	Logger     logr.Logger
 }
 
 type Service struct {
-	appCatalog               string
-	cluster                  *capi.Cluster
-	defaultKeyRotationPeriod time.Duration
-	registryDomain           string
+	appCatalog                 string
+	cluster                    *capi.Cluster
+	clusterCache               *remote.ClusterCache
+	defaultKeyRotationPeriod   time.Duration
+	defaultProvider            v1alpha1.ProviderType
+	defaultResources           []string
+	kms                        KMSConfig
+	rewrite                    RewriteConfig
+	storageVersionMigration    bool
+	controlPlaneRollout        bool
+	ownerReference             bool
+	defaultRetentionPolicy     v1alpha1.RetentionPolicy
+	keyEscrow                  KeyEscrow
+	defaultMaintenanceWindow   *MaintenanceWindow
+	fleet                      *fleet.Coordinator
+	maxAESGCMKeyRotationPeriod time.Duration
+	policy                     *v1alpha1.EncryptionPolicy
+	registryDomain             string
+
+	// deferred is the reason the rotation was deferred in this reconciliation, e.g. "deferred until <time>"
+	deferred string
 
 	ctrlClient ctrlclient.Client
 	logger     logr.Logger
@@ -66,17 +127,59 @@ func New(c Config) (*Service, error) {
 	if c.CtrlClient == nil {
 		return nil, errors.New("ctrlClient cannot be nil")
 	}
+	if c.ClusterCache == nil {
+		return nil, errors.New("ClusterCache cannot be nil")
+	}
 	if c.RegistryDomain == "" {
 		return nil, errors.New("RegistryDomain cannot be empty")
 	}
+	if c.DefaultProvider == "" {
+		c.DefaultProvider = v1alpha1.ProviderSecretbox
+	}
+	if c.DefaultRetentionPolicy == "" {
+		c.DefaultRetentionPolicy = v1alpha1.RetentionPolicyDelete
+	}
+	if err := ValidateRetentionPolicy(c.DefaultRetentionPolicy); err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if c.Rewrite.PageSize <= 0 {
+		c.Rewrite.PageSize = DefaultRewritePageSize
+	}
+	if c.Rewrite.Workers <= 0 {
+		c.Rewrite.Workers = DefaultRewriteWorkers
+	}
+	if len(c.DefaultResources) == 0 {
+		c.DefaultResources = []string{DefaultEncryptedResource}
+	}
+	if c.DefaultProvider == v1alpha1.ProviderKMS {
+		if _, err := newKMSConfiguration(c.KMS); err != nil {
+			return nil, microerror.Mask(err)
+		}
+	} else if _, err := keyLength(c.DefaultProvider); err != nil {
+		return nil, microerror.Mask(err)
+	}
 
 	s := &Service{
-		appCatalog:               c.AppCatalog,
-		cluster:                  c.Cluster,
-		registryDomain:           c.RegistryDomain,
-		defaultKeyRotationPeriod: c.DefaultKeyRotationPeriod,
-		ctrlClient:               c.CtrlClient,
-		logger:                   c.Logger,
+		appCatalog:                 c.AppCatalog,
+		cluster:                    c.Cluster,
+		clusterCache:               c.ClusterCache,
+		registryDomain:             c.RegistryDomain,
+		defaultKeyRotationPeriod:   c.DefaultKeyRotationPeriod,
+		defaultProvider:            c.DefaultProvider,
+		defaultResources:           c.DefaultResources,
+		kms:                        c.KMS,
+		rewrite:                    c.Rewrite,
+		storageVersionMigration:    c.StorageVersionMigration,
+		controlPlaneRollout:        c.ControlPlaneRollout,
+		ownerReference:             c.OwnerReference,
+		defaultRetentionPolicy:     c.DefaultRetentionPolicy,
+		keyEscrow:                  c.KeyEscrow,
+		defaultMaintenanceWindow:   c.MaintenanceWindow,
+		fleet:                      c.Fleet,
+		maxAESGCMKeyRotationPeriod: c.MaxAESGCMKeyRotationPeriod,
+		policy:                     c.Policy,
+		ctrlClient:                 c.CtrlClient,
+		logger:                     c.Logger,
 	}
 
 	return s, nil
@@ -86,24 +189,52 @@ func (s *Service) Reconcile() error {
 	ctx := context.TODO()
 	var encryptionProviderSecret v1.Secret
 
+	conditions.MarkFalse(s.cluster, conditions.EncryptionReconciliationPaused, conditions.NotPausedReason, capi.ConditionSeverityInfo, "")
+
 	err := s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{
 		Name:      key.SecretName(s.cluster.Name),
 		Namespace: s.cluster.Namespace,
 	}, &encryptionProviderSecret)
 
-	if apierrors.IsNotFound(err) {
+	if from, ok := s.cluster.Annotations[RestoreFromAnnotation]; ok && apierrors.IsNotFound(err) {
+		// restore the lost encryption secret instead of generating a new key
+		err := s.restoreEncryptionProviderSecret(ctx, from)
+		if err != nil {
+			conditions.MarkFalse(s.cluster, conditions.EncryptionConfigReady, conditions.EncryptionConfigRestoreFailedReason, capi.ConditionSeverityError, "failed to restore encryption provider config secret from %s: %s", from, err)
+			s.logger.Error(err, fmt.Sprintf("failed to restore encryption provider config secret from %s", from))
+			return microerror.Mask(err)
+		}
+		conditions.MarkTrue(s.cluster, conditions.EncryptionConfigReady, conditions.EncryptionConfigAvailableReason, "restored encryption provider config secret %s from %s", key.SecretName(s.cluster.Name), from)
+	} else if apierrors.IsNotFound(err) {
 		// create new encryption secret
 		err := s.createNewEncryptionProviderSecret(ctx, s.cluster.Name)
-		if err != nil {
+		if IsClusterInitialized(err) {
+			conditions.MarkFalse(s.cluster, conditions.EncryptionConfigReady, conditions.EncryptionConfigMissingReason, capi.ConditionSeverityError,
+				"encryption provider config secret is missing for the initialized cluster, set the %s annotation to restore the keys", RestoreFromAnnotation)
+			s.logger.Error(err, "refusing to generate a new encryption key for initialized cluster")
+			record.Warnf(s.cluster, "EncryptionConfigMissing", "Refusing to generate a new encryption key for the initialized cluster, set the %s annotation to restore the keys or %s to generate a new key", RestoreFromAnnotation, ForceNewKeyAnnotation)
+			return microerror.Mask(err)
+		} else if err != nil {
+			conditions.MarkFalse(s.cluster, conditions.EncryptionConfigReady, conditions.EncryptionConfigFailedReason, capi.ConditionSeverityError, "failed to create encryption provider config secret: %s", err)
 			s.logger.Error(err, "failed to get encryption provider config secret for cluster")
 			return microerror.Mask(err)
 		}
+		conditions.MarkTrue(s.cluster, conditions.EncryptionConfigReady, conditions.EncryptionConfigAvailableReason, "created encryption provider config secret %s", key.SecretName(s.cluster.Name))
 	} else if err != nil {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionConfigReady, conditions.EncryptionConfigFailedReason, capi.ConditionSeverityError, "failed to get encryption provider config secret: %s", err)
 		s.logger.Error(err, "failed to get encryption provider config secret for cluster")
 		return err
 	} else {
+		err = s.reconcileOwnerReference(ctx, &encryptionProviderSecret)
+		if err != nil {
+			s.logger.Error(err, "failed to update owner reference of encryption provider config secret")
+			return microerror.Mask(err)
+		}
+
 		// config already exists, check for key rotation
-		err = s.keyRotation(ctx, encryptionProviderSecret, s.cluster.Name)
+		err = s.keyRotation(ctx, &encryptionProviderSecret, s.cluster.Name)
+		s.setConditions(encryptionProviderSecret)
+		s.updateMetrics(encryptionProviderSecret)
 		if err != nil {
 			return microerror.Mask(err)
 		}
@@ -111,26 +242,74 @@ func (s *Service) Reconcile() error {
 	return nil
 }
 
+// setConditions reports the state of the encryption config and the key rotation on the Cluster
+func (s *Service) setConditions(encryptionProviderSecret v1.Secret) {
+	var ec configv1.EncryptionConfiguration
+	err := yaml.Unmarshal(encryptionProviderSecret.Data[EncryptionProviderConfig], &ec)
+	if err != nil || len(ec.Resources) == 0 {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionConfigReady, conditions.InvalidEncryptionConfigReason, capi.ConditionSeverityError, "encryption provider config secret %s does not contain a valid encryption config", encryptionProviderSecret.Name)
+	} else {
+		conditions.MarkTrue(s.cluster, conditions.EncryptionConfigReady, conditions.EncryptionConfigAvailableReason, "encryption provider config secret %s is available", encryptionProviderSecret.Name)
+	}
+
+	status, err := getRotationStatus(encryptionProviderSecret)
+	if err != nil {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionKeyRotationInProgress, conditions.RotationFailedReason, capi.ConditionSeverityError, "failed to read rotation status: %s", err)
+	} else if status.Phase == v1alpha1.RotationPhaseFailed {
+		conditions.MarkTrue(s.cluster, conditions.EncryptionKeyRotationInProgress, conditions.RotationFailedReason, "key rotation failed in phase %s: %s", status.FailedPhase, status.Message)
+	} else if status.Phase.InProgress() && s.deferred != "" {
+		conditions.MarkTrue(s.cluster, conditions.EncryptionKeyRotationInProgress, string(status.Phase), "key rotation is in phase %s, %s", status.Phase, s.deferred)
+	} else if status.Phase.InProgress() {
+		conditions.MarkTrue(s.cluster, conditions.EncryptionKeyRotationInProgress, string(status.Phase), "key rotation is in phase %s", status.Phase)
+	} else if s.deferred != "" {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionKeyRotationInProgress, conditions.RotationDeferredReason, capi.ConditionSeverityInfo, "key rotation %s", s.deferred)
+	} else if status.Phase == v1alpha1.RotationPhaseCancelled {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionKeyRotationInProgress, conditions.RotationCancelledReason, capi.ConditionSeverityWarning, "key rotation was cancelled, set the %s annotation to rotate again", annotation.EncryptionForceRotation)
+	} else if !s.rotationEnabled(encryptionProviderSecret) {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionKeyRotationInProgress, conditions.RotationDisabledReason, capi.ConditionSeverityInfo, "key rotation is not enabled")
+	} else {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionKeyRotationInProgress, conditions.RotationNotInProgressReason, capi.ConditionSeverityInfo, "")
+	}
+
+	lastRotation, err := lastKeyRotation(encryptionProviderSecret)
+	if err != nil {
+		s.logger.Error(err, "failed to parse time for last rotation")
+		return
+	}
+	keyAge := time.Since(lastRotation).Round(time.Second)
+	if s.provider() == v1alpha1.ProviderKMS {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionKeyRotationStale, conditions.KeyManagedByKMSReason, capi.ConditionSeverityInfo, "encryption keys are managed by the KMS plugin %s", s.kms.Name)
+	} else if keyAge > s.keyRotationPeriod() {
+		conditions.MarkTrue(s.cluster, conditions.EncryptionKeyRotationStale, conditions.KeyOlderThanRotationPeriodReason, "encryption key is %s old, rotation period is %s", keyAge, s.keyRotationPeriod())
+	} else {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionKeyRotationStale, conditions.KeyWithinRotationPeriodReason, capi.ConditionSeverityInfo, "")
+	}
+}
+
+// updateMetrics exposes the key age and the rotation phase of the cluster
+func (s *Service) updateMetrics(encryptionProviderSecret v1.Secret) {
+	lastRotation, err := lastKeyRotation(encryptionProviderSecret)
+	if err == nil {
+		metrics.SetKeyAge(s.cluster.Namespace, s.cluster.Name, time.Since(lastRotation), s.keyRotationPeriod())
+	}
+
+	status, err := getRotationStatus(encryptionProviderSecret)
+	if err == nil {
+		metrics.SetRotationPhase(s.cluster.Namespace, s.cluster.Name, status.Phase)
+	}
+}
+
 func (s *Service) Delete() error {
 	ctx := context.TODO()
-	encryptionProviderSecret := v1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      key.SecretName(s.cluster.Name),
-			Namespace: s.cluster.Namespace,
-		}}
+	metrics.DeleteCluster(s.cluster.Namespace, s.cluster.Name)
 
-	err := s.ctrlClient.Delete(ctx, &encryptionProviderSecret)
-	if apierrors.IsNotFound(err) {
-		// secret is already deleted, fall thru
-		return nil
-	} else if err != nil {
-		s.logger.Error(err, "failed to delete encryption provider config secret for cluster")
-		return microerror.Mask(err)
-	}
+	// drop the cached client, so a cluster recreated with the same name does not use it
+	s.clusterCache.Delete(ctrlclient.ObjectKeyFromObject(s.cluster))
 
-	err = key.CleanWCK8sKubeconfig(s.cluster.Name)
+	// delete, retain or archive the key material depending on the retention policy
+	err := s.retainEncryptionProviderSecret(ctx)
 	if err != nil {
-		s.logger.Error(err, fmt.Sprintf("failed to delete local kubeconfig file for cluster %s", s.cluster.Name))
+		s.logger.Error(err, fmt.Sprintf("failed to apply retention policy %s to encryption provider config secret for cluster", s.retentionPolicy()))
 		return microerror.Mask(err)
 	}
 
@@ -139,7 +318,7 @@ func (s *Service) Delete() error {
 
 func (s *Service) createNewEncryptionProviderSecret(ctx context.Context, clusterName string) error {
 	// check if there is old encryption config that we can use for migration
-	oldEncryptionSecretName := fmt.Sprintf("%s-encryption", clusterName)
+	oldEncryptionSecretName := key.LegacySecretName(clusterName)
 
 	var oldEncryptionSecret v1.Secret
 	err := s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{
@@ -149,27 +328,23 @@ func (s *Service) createNewEncryptionProviderSecret(ctx context.Context, cluster
 		&oldEncryptionSecret)
 
 	var encryptionConfig configv1.EncryptionConfiguration
+	migratedLegacyKey := false
 
 	if apierrors.IsNotFound(err) {
+		if clusterInitialized(s.cluster) && s.cluster.Annotations[ForceNewKeyAnnotation] != "true" {
+			// etcd of the cluster may contain data encrypted with the lost keys, a new key would make it unreadable
+			return microerror.Maskf(clusterInitializedError, "cluster %s is initialized but has no encryption provider config secret", clusterName)
+		}
+
 		// no old key found, lets generate a new one
-		newKey, err := newRandomKey(Poly1305KeyLength)
+		providerConfig, err := s.newProviderConfiguration(ctx)
 		if err != nil {
-			s.logger.Error(err, "failed to generate new random key for encryption")
+			s.logger.Error(err, "failed to generate new encryption provider configuration")
 			return microerror.Mask(err)
 		}
-		s.logger.Info("generated a new encryption key for Poly1305 encryption provider")
+		s.logger.Info(fmt.Sprintf("generated a new encryption config for %s encryption provider", s.provider()))
 
-		providerConfig := configv1.ProviderConfiguration{
-			Secretbox: &configv1.SecretboxConfiguration{
-				Keys: []configv1.Key{
-					{
-						Name:   "key1",
-						Secret: newKey,
-					},
-				},
-			},
-		}
-		encryptionConfig = initNewEncryptionConfigStruct(providerConfig)
+		encryptionConfig = initNewEncryptionConfigStruct(providerConfig, s.resources())
 	} else if err != nil {
 		s.logger.Error(err, "failed to get old encryption provider key secret")
 		return microerror.Mask(err)
@@ -191,7 +366,8 @@ func (s *Service) createNewEncryptionProviderSecret(ctx context.Context, cluster
 					},
 				},
 			}
-			encryptionConfig = initNewEncryptionConfigStruct(providerConfig)
+			encryptionConfig = initNewEncryptionConfigStruct(providerConfig, s.resources())
+			migratedLegacyKey = true
 			s.logger.Info("fetched and migrated AESCBC encryption key from legacy product")
 		}
 	}
@@ -213,6 +389,10 @@ func (s *Service) createNewEncryptionProviderSecret(ctx context.Context, cluster
 		},
 		Data: map[string][]byte{EncryptionProviderConfig: secretData},
 	}
+	_, err = s.setOwnerReference(encryptionProviderSecret, s.ownerReferenceEnabled())
+	if err != nil {
+		return microerror.Mask(err)
+	}
 
 	err = s.ctrlClient.Create(ctx, encryptionProviderSecret)
 	if err != nil {
@@ -221,144 +401,125 @@ func (s *Service) createNewEncryptionProviderSecret(ctx context.Context, cluster
 	}
 
 	s.logger.Info("created a new encryption provider config secret")
+	if migratedLegacyKey {
+		record.Eventf(s.cluster, "LegacyEncryptionKeyMigrated", "Migrated AESCBC encryption key from legacy secret %s to encryption provider config secret %s", oldEncryptionSecretName, encryptionProviderSecret.Name)
+	} else {
+		record.Eventf(s.cluster, "EncryptionKeyGenerated", "Generated new encryption key for encryption provider config secret %s", encryptionProviderSecret.Name)
+	}
 
 	return nil
 }
 
 // keyRotation will handle encryption key rotation in case the configured time period elapsed
 // the controller needs to handle several phases of the rotation as it is require roll of the master nodes and rewriting all the secrets
-func (s *Service) keyRotation(ctx context.Context, encryptionProviderSecret v1.Secret, clusterName string) error {
-	// check if key rotation is already in progress
-	if _, ok := encryptionProviderSecret.Annotations[annotation.EncryptionRotationInProgress]; ok {
-		/*
-			short description of what we do here
-			- we get the k8s client to workload cluster
-			- the workload cluster should run app that run pod on each master node
-			and check the encryption config file on host  and save its md5 sum to the secret called EncryptionProviderConfigShake256SecretName
-			this secret will then have md5 check sum of the file for each master
-			- we fetch the secret and compare the values of md5 checksum with expected value
-			- this has to match for each master node
-			- in case all master nodes has same new config file we can rewrite all secrets in the workload cluster
-		*/
-		// get workload cluster k8s client
-		wcClient, err := key.GetWCK8sClient(ctx, s.ctrlClient, clusterName, s.cluster.Namespace)
+// the phase is persisted on the secret together with the keys so an interrupted rotation resumes at the right step
+func (s *Service) keyRotation(ctx context.Context, encryptionProviderSecret *v1.Secret, clusterName string) error {
+	status, err := getRotationStatus(*encryptionProviderSecret)
+	if err != nil {
+		s.logger.Error(err, "failed to read rotation status from encryption provider secret")
+		return microerror.Mask(err)
+	}
+
+	if status.Phase != "" {
+		err = s.reportRotationStatus(ctx, status)
 		if err != nil {
 			return microerror.Mask(err)
 		}
+	}
 
-		// calculate checksum of the encryption provider config file
-		configShakeSum := shake256Sum(encryptionProviderSecret.Data[EncryptionProviderConfig])
-		masterNodesUpToDate, err := s.areAllMasterNodesUsingLatestConfig(ctx, wcClient, configShakeSum)
+	if _, ok := encryptionProviderSecret.Annotations[v1alpha1.CancelRotationAnnotation]; ok {
+		err = s.cancelRotation(ctx, encryptionProviderSecret, status)
 		if err != nil {
+			s.logger.Error(err, "failed to cancel key rotation")
 			return microerror.Mask(err)
 		}
+	}
 
-		if masterNodesUpToDate {
-			// rewrite all secrets in workload cluster so new keys is used for encryption
-			err = rewriteAllSecrets(wcClient, ctx)
-			if err != nil {
-				s.logger.Error(err, "failed to rewrite all secrets in workload cluster cluster")
-				return err
-			}
-			s.logger.Info("all secrets on the workload cluster has been rewritten with the new encryption key")
+	// check if key rotation is already in progress
+	if status.Phase.InProgress() {
+		return s.continueRotation(ctx, encryptionProviderSecret, status, clusterName)
+	}
 
-			// delete the app that watches the encryption config
-			err = s.deleteEncryptionProviderHasherApp(ctx, wcClient)
-			if err != nil {
-				s.logger.Error(err, "failed to delete encryption-config-hasher app from workload cluster")
-				return microerror.Mask(err)
-			} else {
-				s.logger.Info("removed encryption-config-hasher app from workload cluster")
-			}
+	// a cancelled rotation is only started again if forced, as it would add a new key right away
+	if _, ok := encryptionProviderSecret.Annotations[annotation.EncryptionForceRotation]; status.Phase == v1alpha1.RotationPhaseCancelled && !ok {
+		s.logger.Info("last key rotation was cancelled, not rotating until the rotation is forced")
+		return nil
+	}
 
-			err = removeOldEncryptionKey(&encryptionProviderSecret)
-			if err != nil {
-				s.logger.Error(err, "failed to remove old encryption key from the configuration secret")
-				return err
-			}
-			s.logger.Info("removed old key from the encryption config")
+	// key rotation is not in progress
+	// check if the rotation should be started
+	changed, err := s.providerChanged(*encryptionProviderSecret)
+	if err != nil {
+		s.logger.Error(err, "failed to read current encryption provider from encryption provider secret")
+		return microerror.Mask(err)
+	}
 
-			encryptionProviderSecret.Annotations[annotation.EncryptionLastRotation] = time.Now().Format(time.RFC3339)
-			delete(encryptionProviderSecret.Annotations, annotation.EncryptionRotationInProgress)
-			err = s.ctrlClient.Update(ctx, &encryptionProviderSecret)
-			if err != nil {
-				s.logger.Error(err, "failed to update encryption provider secret")
-				return microerror.Mask(err)
-			}
-		} else {
-			// update the chart app in case there has been a change
-			err = s.deployEncryptionProviderHasherApp(ctx, wcClient)
-			if err != nil {
-				return microerror.Mask(err)
-			}
+	resourcesChanged, err := resourcesChanged(*encryptionProviderSecret, s.resources())
+	if err != nil {
+		s.logger.Error(err, "failed to read encrypted resources from encryption provider secret")
+		return microerror.Mask(err)
+	}
+
+	// switching the encryption provider or the encrypted resources is done with a key rotation,
+	// so all resources are rewritten with the new key
+	if changed || resourcesChanged {
+		deferred, err := s.deferNewRotation(ctx, time.Time{})
+		if err != nil {
+			return microerror.Mask(err)
+		} else if deferred {
+			return nil
 		}
-		// key rotation is not in progress
-		// check if the rotation should be started
-	} else if _, ok := encryptionProviderSecret.Annotations[annotation.EncryptionEnableRotation]; ok {
-		addNewKeyForRotation := false
 
-		if t, ok := encryptionProviderSecret.Annotations[annotation.EncryptionLastRotation]; ok {
-			lastRotation, err := time.Parse(time.RFC3339, t)
-			if err != nil {
-				s.logger.Error(err, "failed to parse time for last rotation")
-				return microerror.Mask(err)
-			}
+		s.logger.Info(fmt.Sprintf("encryption provider %s or encrypted resources %v changed, rotating key", s.provider(), s.resources()))
+		err = s.startRotation(ctx, encryptionProviderSecret, status)
+		if err != nil {
+			return microerror.Mask(err)
+		}
 
-			if time.Since(lastRotation) > s.defaultKeyRotationPeriod {
-				addNewKeyForRotation = true
-			}
+		return s.continueRotation(ctx, encryptionProviderSecret, status, clusterName)
+	}
+
+	s.fleet.SetPeriodic(ctrlclient.ObjectKeyFromObject(s.cluster), s.rotationEnabled(*encryptionProviderSecret))
+	if s.rotationEnabled(*encryptionProviderSecret) {
+		addNewKeyForRotation := false
+		var cycleStart time.Time
+		keyRotationPeriod := s.keyRotationPeriod()
 
-			// the annotation regarding last rotation is missing so assume this is new cluster
-			// use creation timestamp to calculate elapsed time
-		} else if time.Since(encryptionProviderSecret.CreationTimestamp.Time) > s.defaultKeyRotationPeriod {
+		lastRotation, err := lastKeyRotation(*encryptionProviderSecret)
+		if err != nil {
+			s.logger.Error(err, "failed to parse time for last rotation")
+			return microerror.Mask(err)
+		}
+
+		// the jitter spreads the rotations of clusters created at the same time
+		if time.Since(lastRotation) > keyRotationPeriod-s.fleet.Jitter(ctrlclient.ObjectKeyFromObject(s.cluster), keyRotationPeriod) {
 			addNewKeyForRotation = true
+			// the previous waves have to rotate in the same cycle
+			cycleStart = lastRotation
 		}
 
 		// check if the key rotation is forced by the annotation
 		if _, ok := encryptionProviderSecret.Annotations[annotation.EncryptionForceRotation]; ok {
 			addNewKeyForRotation = true
+			cycleStart = time.Time{}
 		}
 
 		if addNewKeyForRotation {
-			// generate new encryption key
-			newKey, err := newRandomKey(Poly1305KeyLength)
-			if err != nil {
-				s.logger.Error(err, "failed to generate new encryption key")
-				return microerror.Mask(err)
-			}
-			err = addNewEncryptionKey(&encryptionProviderSecret, newKey)
+			deferred, err := s.deferNewRotation(ctx, cycleStart)
 			if err != nil {
-				s.logger.Error(err, "failed to add new encryption key to the configuration secret")
-				return microerror.Mask(err)
-			}
-
-			// get workload cluster k8s client
-			wcClient, err := key.GetWCK8sClient(ctx, s.ctrlClient, clusterName, s.cluster.Namespace)
-			if err != nil {
-				return microerror.Mask(err)
-			}
-
-			// deploy the app that watches the encryption config
-			err = s.deployEncryptionProviderHasherApp(ctx, wcClient)
-			if err != nil {
-				s.logger.Error(err, "failed to deploy encryption-config-hasher app to workload cluster")
 				return microerror.Mask(err)
+			} else if deferred {
+				return nil
 			}
 
-			// keys added, set the new phase on the object
-			encryptionProviderSecret.Annotations[annotation.EncryptionRotationInProgress] = "true"
-			// delete the Force rotation annotation if it exists
-			delete(encryptionProviderSecret.Annotations, annotation.EncryptionForceRotation)
-
-			// update the object
-			err = s.ctrlClient.Update(ctx, &encryptionProviderSecret)
+			err = s.startRotation(ctx, encryptionProviderSecret, status)
 			if err != nil {
-				s.logger.Error(err, "failed to update encryption provider secret")
 				return microerror.Mask(err)
 			}
 
+			return s.continueRotation(ctx, encryptionProviderSecret, status, clusterName)
 		} else {
-			s.logger.Info(fmt.Sprintf("keys are not %s old, not rotating", s.defaultKeyRotationPeriod.String()))
+			s.logger.Info(fmt.Sprintf("keys are not %s old, not rotating", keyRotationPeriod.String()))
 		}
 
 	} else {
@@ -368,6 +529,84 @@ func (s *Service) keyRotation(ctx context.Context, encryptionProviderSecret v1.S
 	return nil
 }
 
+// resources returns the encrypted resources from the EncryptionPolicy or the operator default
+func (s *Service) resources() []string {
+	if s.policy != nil && len(s.policy.Spec.Resources) > 0 {
+		return s.policy.Spec.Resources
+	}
+
+	return s.defaultResources
+}
+
+// provider returns the encryption provider from the EncryptionPolicy or the operator default
+func (s *Service) provider() v1alpha1.ProviderType {
+	if s.policy != nil && s.policy.Spec.Provider != "" {
+		return s.policy.Spec.Provider
+	}
+
+	return s.defaultProvider
+}
+
+// rotationEnabled returns true if periodic key rotation is enabled for the cluster,
+// the EncryptionPolicy takes precedence over the annotation on the secret, aesgcm is always rotated and kms never
+func (s *Service) rotationEnabled(encryptionProviderSecret v1.Secret) bool {
+	// AES-GCM keys must be rotated regularly as the nonce space of a single key is limited
+	if s.provider() == v1alpha1.ProviderAESGCM {
+		return true
+	}
+	// KMS keys are rotated in the KMS, the operator only migrates to and from the KMS provider
+	if s.provider() == v1alpha1.ProviderKMS {
+		return false
+	}
+
+	if s.policy != nil {
+		return s.policy.Spec.Rotation.Enabled
+	}
+
+	_, ok := encryptionProviderSecret.Annotations[annotation.EncryptionEnableRotation]
+	return ok
+}
+
+// ValidateKeyRotationPeriod returns an error if the rotation period of an EncryptionPolicy is not positive
+func ValidateKeyRotationPeriod(period *metav1.Duration) error {
+	if period != nil && period.Duration <= 0 {
+		return microerror.Mask(fmt.Errorf("key rotation period must be positive, got %s", period.Duration))
+	}
+
+	return nil
+}
+
+// keyRotationPeriod returns the rotation period from the EncryptionPolicy or the operator default,
+// for aesgcm the period is capped to the maximal AES-GCM key rotation period
+func (s *Service) keyRotationPeriod() time.Duration {
+	period := s.defaultKeyRotationPeriod
+	if s.policy != nil && s.policy.Spec.Rotation.Period != nil {
+		period = s.policy.Spec.Rotation.Period.Duration
+	}
+
+	if s.provider() == v1alpha1.ProviderAESGCM && s.maxAESGCMKeyRotationPeriod > 0 && period > s.maxAESGCMKeyRotationPeriod {
+		return s.maxAESGCMKeyRotationPeriod
+	}
+
+	return period
+}
+
+// lastKeyRotation returns the time of the last key rotation, for clusters which were never rotated
+// the creation timestamp of the secret is used
+func lastKeyRotation(encryptionProviderSecret v1.Secret) (time.Time, error) {
+	t, ok := encryptionProviderSecret.Annotations[annotation.EncryptionLastRotation]
+	if !ok {
+		return encryptionProviderSecret.CreationTimestamp.Time, nil
+	}
+
+	lastRotation, err := time.Parse(time.RFC3339, t)
+	if err != nil {
+		return time.Time{}, microerror.Mask(err)
+	}
+
+	return lastRotation, nil
+}
+
 // newRandomKey generates a new random key with defined length
 func newRandomKey(length int) (string, error) {
 	randomKey := make([]byte, length)
@@ -382,45 +621,25 @@ func newRandomKey(length int) (string, error) {
 
 // addNewProviderKey will add a new key to the config in a way that its always on the first position
 // only key on the first position is used to write new secrets to the storage
-// if the same encryption provider already exists it will add the key to it otherwise it will ad the new provider at the start
-func addNewEncryptionKey(secret *v1.Secret, newEncryptionKey string) error {
+// if the same encryption provider already exists it will be moved to the first position and the key added to it,
+// otherwise it will ad the new provider at the start
+// the key is added to every resource configuration except the ones used to decrypt removed resources
+func addNewEncryptionKey(secret *v1.Secret, provider v1alpha1.ProviderType, newEncryptionKey string) error {
 	var ec configv1.EncryptionConfiguration
 	err := yaml.Unmarshal(secret.Data[EncryptionProviderConfig], &ec)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
-	added := false
-	for _, p := range ec.Resources[0].Providers {
-		if p.Secretbox != nil {
-			if len(p.Secretbox.Keys) > 1 {
-				// there are already 2 keys in the config, dont add another one
-				return nil
-			}
-			i, err := getMaxKeyIndex(p.Secretbox.Keys)
-			if err != nil {
-				return microerror.Mask(err)
-			}
-			// secretbox configuration exists add a new key at the start of the array
-			p.Secretbox.Keys = append([]configv1.Key{{Secret: newEncryptionKey, Name: keyName(i + 1)}}, p.Secretbox.Keys...)
-			added = true
-			break
+	for i := range ec.Resources {
+		if isDecryptingResourceConfiguration(ec.Resources[i]) {
+			continue
 		}
-	}
 
-	// provider secret box is not yet present in the config so add the whole configuration
-	if !added {
-		secretBoxProvider := configv1.ProviderConfiguration{
-			Secretbox: &configv1.SecretboxConfiguration{
-				Keys: []configv1.Key{
-					{
-						Name:   keyName(1),
-						Secret: newEncryptionKey,
-					},
-				},
-			},
+		ec.Resources[i].Providers, err = addKeyToProviders(ec.Resources[i].Providers, provider, newEncryptionKey)
+		if err != nil {
+			return microerror.Mask(err)
 		}
-		ec.Resources[0].Providers = append([]configv1.ProviderConfiguration{secretBoxProvider}, ec.Resources[0].Providers...)
 	}
 
 	o, err := yaml.Marshal(ec)
@@ -431,8 +650,40 @@ func addNewEncryptionKey(secret *v1.Secret, newEncryptionKey string) error {
 	return nil
 }
 
-// removeOldEncryptionKey will either remove legacy aescbc provider config and its key
-// or remove the last encryption key in secretbox provider
+func addKeyToProviders(providers []configv1.ProviderConfiguration, provider v1alpha1.ProviderType, newEncryptionKey string) ([]configv1.ProviderConfiguration, error) {
+	for i, p := range providers {
+		if providerType(p) != string(provider) {
+			continue
+		}
+
+		keys := providerKeys(p)
+		if i == 0 && len(*keys) > 1 {
+			// there are already 2 keys in the config, dont add another one
+			return providers, nil
+		}
+		idx, err := getMaxKeyIndex(*keys)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		// provider configuration exists add a new key at the start of the array
+		*keys = append([]configv1.Key{{Secret: newEncryptionKey, Name: keyName(idx + 1)}}, *keys...)
+
+		// move the provider to the first position, so the new key is used for writing
+		moved := append([]configv1.ProviderConfiguration{p}, providers[:i]...)
+		return append(moved, providers[i+1:]...), nil
+	}
+
+	// provider is not yet present in the config so add the whole configuration
+	newProvider, err := newProviderConfiguration(provider, configv1.Key{Name: keyName(1), Secret: newEncryptionKey})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return append([]configv1.ProviderConfiguration{newProvider}, providers...), nil
+}
+
+// removeOldEncryptionKey will either remove the providers replaced by the first one (like the legacy aescbc provider)
+// or remove the last encryption key of the first provider
+// resource configurations used to decrypt removed resources are dropped as the resources were already rewritten
 func removeOldEncryptionKey(secret *v1.Secret) error {
 	var ec configv1.EncryptionConfiguration
 	err := yaml.Unmarshal(secret.Data[EncryptionProviderConfig], &ec)
@@ -440,68 +691,47 @@ func removeOldEncryptionKey(secret *v1.Secret) error {
 		return microerror.Mask(err)
 	}
 
-	// try to remove legacy aescbc provider if present
-	for i, p := range ec.Resources[0].Providers {
-		if p.AESCBC != nil {
-			// found AESCBC provider configuration, should be removed
-			ec.Resources[0].Providers = append(ec.Resources[0].Providers[:i], ec.Resources[0].Providers[i+1:]...)
-
-			o, err := yaml.Marshal(ec)
-			if err != nil {
-				return microerror.Mask(err)
-			}
-			secret.Data[EncryptionProviderConfig] = o
-			return nil
+	var resources []configv1.ResourceConfiguration
+	for _, rc := range ec.Resources {
+		if isDecryptingResourceConfiguration(rc) {
+			continue
 		}
-	}
 
-	// if no aescbc provider present, remove the last key from the secretbox provider
-	for _, p := range ec.Resources[0].Providers {
-		if p.Secretbox != nil {
-			keysCount := len(p.Secretbox.Keys)
-			if keysCount > 1 {
-				// remove the last key from the array only if there are at least 2 keys
-				p.Secretbox.Keys = p.Secretbox.Keys[:keysCount-1]
-			}
-
-			o, err := yaml.Marshal(ec)
-			if err != nil {
-				return microerror.Mask(err)
-			}
-			secret.Data[EncryptionProviderConfig] = o
-		}
+		rc.Providers = removeOldProviders(rc.Providers)
+		resources = append(resources, rc)
 	}
-	return nil
-}
+	ec.Resources = resources
 
-// rewriteAllSecrets will load all secrets from cluster, add an annotation that marks that it has been rewriten
-// and updates them in API
-func rewriteAllSecrets(wcClient ctrlclient.Client, ctx context.Context) error {
-	var allSecrets v1.SecretList
-	err := wcClient.List(ctx, &allSecrets)
+	o, err := yaml.Marshal(ec)
 	if err != nil {
 		return microerror.Mask(err)
 	}
+	secret.Data[EncryptionProviderConfig] = o
+	return nil
+}
 
-	timestamp := time.Now().Format(time.RFC3339)
-
-	for i := range allSecrets.Items {
-		if allSecrets.Items[i].Annotations == nil {
-			allSecrets.Items[i].Annotations = map[string]string{}
+func removeOldProviders(oldProviders []configv1.ProviderConfiguration) []configv1.ProviderConfiguration {
+	// try to remove old providers if present, identity is always kept as the last fallback
+	providers := []configv1.ProviderConfiguration{oldProviders[0]}
+	for _, p := range oldProviders[1:] {
+		if providerType(p) == providerIdentity {
+			providers = append(providers, p)
 		}
-		allSecrets.Items[i].Annotations[annotation.EncryptionRewriteTimestamp] = timestamp
-
-		err = wcClient.Update(ctx, &allSecrets.Items[i])
-		if apierrors.IsNotFound(err) {
-			// secret was deleted just ignore and fall thru
-		} else if err != nil {
-			return microerror.Mask(err)
+	}
+	if len(providers) == len(oldProviders) {
+		// if no old provider present, remove the last key from the first provider
+		if keys := providerKeys(providers[0]); keys != nil && len(*keys) > 1 {
+			// remove the last key from the array only if there are at least 2 keys
+			*keys = (*keys)[:len(*keys)-1]
 		}
 	}
-	return nil
+
+	return providers
 }
 
-func (s *Service) areAllMasterNodesUsingLatestConfig(ctx context.Context, wcClient ctrlclient.Client, configShake256Sum string) (bool, error) {
+// areAllMasterNodesUsingLatestConfig returns true if all control plane nodes run the config with the given checksum,
+// otherwise it returns the reason for waiting
+func (s *Service) areAllMasterNodesUsingLatestConfig(ctx context.Context, wcClient ctrlclient.Client, configShake256Sum string) (bool, string, error) {
 	// get the secret with md5 checksums of the config file
 	var shake256Secret v1.Secret
 	err := wcClient.Get(ctx,
@@ -512,14 +742,16 @@ func (s *Service) areAllMasterNodesUsingLatestConfig(ctx context.Context, wcClie
 		&shake256Secret)
 	if apierrors.IsNotFound(err) {
 		// secret does not exist yet, not and actual error, lets check next reconciliation loop
-		s.logger.Info(fmt.Sprintf("secret %s do not exists yet on the workload cluster", EncryptionProviderConfigShake256SecretName))
-		return false, nil
+		reason := fmt.Sprintf("secret %s do not exists yet on the workload cluster", EncryptionProviderConfigShake256SecretName)
+		s.logger.Info(reason)
+		return false, reason, nil
 	} else if err != nil {
-		return false, microerror.Mask(err)
+		return false, "", microerror.Mask(err)
 	}
 
-	// get all master nodeItems
+	// get all master nodeItems, a node can carry both master labels
 	nodeItems := []v1.Node{}
+	seen := map[string]bool{}
 	for _, label := range key.MasterNodeLabels {
 		var tmpNodes v1.NodeList
 		err = wcClient.List(ctx,
@@ -527,16 +759,35 @@ func (s *Service) areAllMasterNodesUsingLatestConfig(ctx context.Context, wcClie
 			ctrlclient.MatchingLabels{label: ""},
 		)
 		if err != nil {
-			return false, microerror.Mask(err)
+			return false, "", microerror.Mask(err)
+		}
+		for _, n := range tmpNodes.Items {
+			if !seen[n.Name] {
+				seen[n.Name] = true
+				nodeItems = append(nodeItems, n)
+			}
 		}
-		nodeItems = append(nodeItems, tmpNodes.Items...)
 	}
 
 	nodeCount := len(nodeItems)
-	if nodeCount != 1 && nodeCount != 3 && nodeCount != 5 {
-		err = errors.New("unexpected number of master nodes, cluster is probably in transiting state")
-		s.logger.Error(err, fmt.Sprintf("expected 1 or 3 or 5 master nodes but found %d", nodeCount))
-		return false, nil
+	_, reason, err := s.expectedControlPlaneNodes(ctx, nodeCount)
+	if err != nil {
+		return false, "", microerror.Mask(err)
+	} else if reason != "" {
+		s.logger.Info(reason)
+		return false, reason, nil
+	}
+
+	readyNodes := 0
+	for i := range nodeItems {
+		if key.IsNodeReady(&nodeItems[i]) {
+			readyNodes += 1
+		}
+	}
+	if readyNodes != nodeCount {
+		reason = fmt.Sprintf("%d of %d control plane nodes are ready", readyNodes, nodeCount)
+		s.logger.Info(reason)
+		return false, reason, nil
 	}
 
 	masterNodeWithLatestConfig := 0
@@ -549,23 +800,26 @@ func (s *Service) areAllMasterNodesUsingLatestConfig(ctx context.Context, wcClie
 		}
 	}
 
+	metrics.SetControlPlaneNodes(s.cluster.Namespace, s.cluster.Name, nodeCount, masterNodeWithLatestConfig)
+
 	if masterNodeWithLatestConfig == nodeCount {
 		s.logger.Info(fmt.Sprintf("all masters are running updated encryption provider config (%d/%d are up to date)", masterNodeWithLatestConfig, nodeCount))
-		return true, nil
+		record.Eventf(s.cluster, "ControlPlaneConverged", "All %d control plane nodes are running the latest encryption provider config", nodeCount)
+		return true, "", nil
 	}
 
 	s.logger.Info(fmt.Sprintf("not all masters are running updated encryption provider config (%d/%d are up to date)", masterNodeWithLatestConfig, nodeCount))
-	return false, nil
+	return false, fmt.Sprintf("%d of %d control plane nodes use the latest encryption provider config", masterNodeWithLatestConfig, nodeCount), nil
 }
 
 // initNewEncryptionConfigStruct will build struct for the encryption configuration
-func initNewEncryptionConfigStruct(provider configv1.ProviderConfiguration) configv1.EncryptionConfiguration {
+func initNewEncryptionConfigStruct(provider configv1.ProviderConfiguration, resources []string) configv1.EncryptionConfiguration {
 	return configv1.EncryptionConfiguration{
 		Kind:       "EncryptionConfig",
 		APIVersion: "v1",
 		Resources: []configv1.ResourceConfiguration{
 			{
-				Resources: []string{"secrets"},
+				Resources: resources,
 				Providers: []configv1.ProviderConfiguration{
 					provider,
 					{
@@ -10,6 +10,7 @@ import (
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
 
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
 	configv1 "github.com/giantswarm/encryption-provider-operator/pkg/config"
 )
 
@@ -115,6 +116,123 @@ resources:
       - name: key1
         secret: testkey1
   - identity: {}
+`)},
+			},
+		},
+		{
+			name: "case 3:  remove old secretbox provider after switch to aesgcm",
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - aesgcm:
+      keys:
+      - name: key1
+        secret: testkey1
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+      - name: key1
+        secret: testkey0
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - aesgcm:
+      keys:
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+		},
+		{
+			name: "case 4:  remove old secretbox provider after migration to kms",
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - kms:
+      apiVersion: v2
+      name: vault
+      endpoint: unix:///var/run/kmsplugin/socket.sock
+  - secretbox:
+      keys:
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - kms:
+      apiVersion: v2
+      name: vault
+      endpoint: unix:///var/run/kmsplugin/socket.sock
+  - identity: {}
+`)},
+			},
+		},
+		{
+			name: "case 5:  remove decrypted resources and old key of encrypted resources",
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - configmaps
+  providers:
+  - identity: {}
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+      - name: key1
+        secret: testkey1
+- resources:
+  - secrets
+  - '*.cert-manager.io'
+  providers:
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  - '*.cert-manager.io'
+  providers:
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+  - identity: {}
 `)},
 			},
 		},
@@ -138,11 +256,13 @@ resources:
 func Test_addNewEncryptionKey(t *testing.T) {
 	testCases := []struct {
 		name           string
+		provider       v1alpha1.ProviderType
 		secret         v1.Secret
 		expectedSecret v1.Secret
 	}{
 		{
-			name: "case 0: add new key to config with secretbox provider",
+			name:     "case 0: add new key to config with secretbox provider",
+			provider: v1alpha1.ProviderSecretbox,
 			secret: v1.Secret{
 				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
 apiVersion: v1
@@ -175,7 +295,8 @@ resources:
 			},
 		},
 		{
-			name: "case 1: add new key to config with aescbc provider",
+			name:     "case 1: add new key to config with aescbc provider",
+			provider: v1alpha1.ProviderSecretbox,
 			secret: v1.Secret{
 				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
 apiVersion: v1
@@ -206,6 +327,118 @@ resources:
       - name: key1
         secret: testkey1
   - identity: {}
+`)},
+			},
+		},
+		{
+			name:     "case 2: add new key to config with aesgcm provider",
+			provider: v1alpha1.ProviderAESGCM,
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - aesgcm:
+      keys:
+      - name: key3
+        secret: testkey3
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - aesgcm:
+      keys:
+      - name: key4
+        secret: testkey0
+      - name: key3
+        secret: testkey3
+  - identity: {}
+`)},
+			},
+		},
+		{
+			name:     "case 3: switch config with secretbox provider to aesgcm",
+			provider: v1alpha1.ProviderAESGCM,
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - aesgcm:
+      keys:
+      - name: key1
+        secret: testkey0
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+  - identity: {}
+`)},
+			},
+		},
+		{
+			name:     "case 4: switch back to the secretbox provider which is still in the config",
+			provider: v1alpha1.ProviderSecretbox,
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - aesgcm:
+      keys:
+      - name: key1
+        secret: testkey1
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - secretbox:
+      keys:
+      - name: key3
+        secret: testkey0
+      - name: key2
+        secret: testkey2
+  - aesgcm:
+      keys:
+      - name: key1
+        secret: testkey1
+  - identity: {}
 `)},
 			},
 		},
@@ -214,7 +447,7 @@ resources:
 	for i, tc := range testCases {
 		tc := tc
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			err := addNewEncryptionKey(&tc.secret, "testkey0")
+			err := addNewEncryptionKey(&tc.secret, tc.provider, "testkey0")
 			if err != nil {
 				t.Fatalf(" %s : failed to add new encryption key to config %s", tc.name, err)
 			}
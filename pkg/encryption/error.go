@@ -0,0 +1,12 @@
+package encryption
+
+import "github.com/giantswarm/microerror"
+
+var clusterInitializedError = &microerror.Error{
+	Kind: "clusterInitializedError",
+}
+
+// IsClusterInitialized asserts clusterInitializedError.
+func IsClusterInitialized(err error) bool {
+	return microerror.Cause(err) == clusterInitializedError
+}
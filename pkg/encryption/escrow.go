@@ -0,0 +1,95 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
+)
+
+// KeyEscrow stores the generated encryption keys outside of the management cluster.
+type KeyEscrow interface {
+	// Store writes the key to the escrow, storing the same key again must succeed.
+	Store(ctx context.Context, k EscrowedKey) error
+	// Verify returns an error if the key is not escrowed or the escrowed key differs.
+	Verify(ctx context.Context, k EscrowedKey) error
+	// List returns all escrowed keys of the cluster, it is used to restore the encryption config.
+	List(ctx context.Context, namespace string, cluster string) ([]EscrowedKey, error)
+}
+
+// EscrowedKey is a generated encryption key of a cluster.
+type EscrowedKey struct {
+	Namespace string
+	Cluster   string
+	// Name is the name of the key in the encryption provider config.
+	Name string
+	// Provider is the type of the encryption provider using the key.
+	Provider string
+	// Secret is the base64 encoded key.
+	Secret    string
+	CreatedAt time.Time
+}
+
+// newEscrowedKey returns the key of the cluster to escrow
+func (s *Service) newEscrowedKey(name string, provider string, secret string) EscrowedKey {
+	return EscrowedKey{
+		Namespace: s.cluster.Namespace,
+		Cluster:   s.cluster.Name,
+		Name:      name,
+		Provider:  provider,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// escrowKey stores the key in the escrow and reads it back, the key must not be used before this succeeded
+func (s *Service) escrowKey(ctx context.Context, k EscrowedKey) error {
+	if s.keyEscrow == nil {
+		return nil
+	}
+
+	err := s.keyEscrow.Store(ctx, k)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	err = s.keyEscrow.Verify(ctx, k)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	s.logger.Info(fmt.Sprintf("escrowed %s encryption key %s", k.Provider, k.Name))
+	record.Eventf(s.cluster, "EncryptionKeyEscrowVerified", "Escrowed %s encryption key %s to the external key escrow", k.Provider, k.Name)
+
+	return nil
+}
+
+// verifyCurrentKeyEscrow checks the newest key of the config is escrowed, a missing key is escrowed again,
+// e.g. if the escrow was configured after the rotation started
+func (s *Service) verifyCurrentKeyEscrow(ctx context.Context, secret v1.Secret) error {
+	if s.keyEscrow == nil {
+		return nil
+	}
+
+	current, err := currentProvider(secret)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	keys := providerKeys(current)
+	if keys == nil || len(*keys) == 0 {
+		// provider without local keys like kms
+		return nil
+	}
+
+	k := s.newEscrowedKey((*keys)[0].Name, providerType(current), (*keys)[0].Secret)
+	err = s.keyEscrow.Verify(ctx, k)
+	if err != nil {
+		s.logger.Info(fmt.Sprintf("%s encryption key %s is not escrowed, escrowing it again: %s", k.Provider, k.Name, err))
+		return s.escrowKey(ctx, k)
+	}
+
+	return nil
+}
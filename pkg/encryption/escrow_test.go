@@ -0,0 +1,92 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+)
+
+type testKeyEscrow struct {
+	keys     map[string]string
+	escrowed []EscrowedKey
+	storeErr error
+}
+
+func (e *testKeyEscrow) Store(ctx context.Context, k EscrowedKey) error {
+	if e.storeErr != nil {
+		return e.storeErr
+	}
+	e.keys[k.Provider+"/"+k.Name] = k.Secret
+	e.escrowed = append(e.escrowed, k)
+	return nil
+}
+
+func (e *testKeyEscrow) List(ctx context.Context, namespace string, cluster string) ([]EscrowedKey, error) {
+	var keys []EscrowedKey
+	for _, k := range e.escrowed {
+		if k.Namespace == namespace && k.Cluster == cluster {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (e *testKeyEscrow) Verify(ctx context.Context, k EscrowedKey) error {
+	if e.keys[k.Provider+"/"+k.Name] != k.Secret {
+		return fmt.Errorf("key %s is not escrowed", k.Name)
+	}
+	return nil
+}
+
+func Test_addNewProvider_escrow(t *testing.T) {
+	ctx := context.Background()
+	escrow := &testKeyEscrow{keys: map[string]string{}}
+	s := &Service{
+		cluster:         &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"}},
+		defaultProvider: v1alpha1.ProviderSecretbox,
+		keyEscrow:       escrow,
+		logger:          logr.Discard(),
+	}
+
+	// the new key is escrowed under its name in the config
+	secret := testSecretboxConfig("key1")
+	err := s.addNewProvider(ctx, &secret)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	keys, err := configKeys(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if escrow.keys["secretbox/key2"] != keys[0].Secret {
+		t.Fatalf("expected new key2 to be escrowed but got %v", escrow.keys)
+	}
+	err = s.verifyCurrentKeyEscrow(ctx, secret)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	// a key missing in the escrow is escrowed again before the rotation advances
+	delete(escrow.keys, "secretbox/key2")
+	err = s.verifyCurrentKeyEscrow(ctx, secret)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if escrow.keys["secretbox/key2"] != keys[0].Secret {
+		t.Fatalf("expected key2 to be escrowed again but got %v", escrow.keys)
+	}
+
+	// the rotation must not start with a key which could not be escrowed
+	escrow.storeErr = fmt.Errorf("escrow unavailable")
+	secret = testSecretboxConfig("key1")
+	err = s.addNewProvider(ctx, &secret)
+	if err == nil {
+		t.Fatalf("expected error if the key could not be escrowed")
+	}
+}
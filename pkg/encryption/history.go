@@ -0,0 +1,227 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/k8smetadata/pkg/label"
+	"github.com/giantswarm/microerror"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	configv1 "github.com/giantswarm/encryption-provider-operator/pkg/config"
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+	"github.com/giantswarm/encryption-provider-operator/pkg/project"
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
+)
+
+// KeyHistory is the data key of the escrowed keys in the key history secret and in archives.
+const KeyHistory = "key-history"
+
+// HistoricKey is a retired encryption key, it is needed to restore etcd snapshots taken while it was active.
+type HistoricKey struct {
+	// Name is the name of the key in the encryption provider config.
+	Name string `json:"name"`
+	// Provider is the type of the encryption provider using the key, e.g. secretbox or aescbc.
+	Provider string `json:"provider"`
+	// Secret is the base64 encoded key.
+	Secret string `json:"secret"`
+	// ActiveFrom is the time the key started to encrypt new data.
+	ActiveFrom time.Time `json:"activeFrom"`
+	// ActiveUntil is the time a new key replaced the key for encrypting new data.
+	ActiveUntil time.Time `json:"activeUntil"`
+}
+
+// getKeyHistory returns the key history secret of the cluster and the escrowed keys, the secret is nil if no key was retired yet
+func (s *Service) getKeyHistory(ctx context.Context) (*v1.Secret, []HistoricKey, error) {
+	var secret v1.Secret
+	err := s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{
+		Name:      key.KeyHistorySecretName(s.cluster.Name),
+		Namespace: s.cluster.Namespace,
+	}, &secret)
+	if apierrors.IsNotFound(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, microerror.Mask(err)
+	}
+
+	history, err := decodeKeyHistory(secret.Data[KeyHistory])
+	if err != nil {
+		return nil, nil, microerror.Mask(err)
+	}
+
+	return &secret, history, nil
+}
+
+// decodeKeyHistory decodes the escrowed keys stored in a key history secret or an archive
+func decodeKeyHistory(data []byte) ([]HistoricKey, error) {
+	var history []HistoricKey
+	if len(data) == 0 {
+		return history, nil
+	}
+
+	err := json.Unmarshal(data, &history)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return history, nil
+}
+
+// configKeys returns all local keys of the encryption config, the keys of providers without local keys like kms are not included
+func configKeys(secret v1.Secret) ([]HistoricKey, error) {
+	var ec configv1.EncryptionConfiguration
+	err := yaml.Unmarshal(secret.Data[EncryptionProviderConfig], &ec)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var keys []HistoricKey
+	for _, rc := range ec.Resources {
+		for _, p := range rc.Providers {
+			providerKeys := providerKeys(p)
+			if providerKeys == nil {
+				continue
+			}
+			for _, k := range *providerKeys {
+				keys = appendHistoricKey(keys, HistoricKey{Name: k.Name, Provider: providerType(p), Secret: k.Secret})
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// appendHistoricKey appends the key unless the same key of the same provider is already in the list
+func appendHistoricKey(keys []HistoricKey, k HistoricKey) []HistoricKey {
+	for _, existing := range keys {
+		if existing.Provider == k.Provider && existing.Name == k.Name && existing.Secret == k.Secret {
+			return keys
+		}
+	}
+
+	return append(keys, k)
+}
+
+// retiredKeys returns the keys of the old config which are not part of the new config anymore
+func retiredKeys(oldSecret v1.Secret, newSecret v1.Secret) ([]HistoricKey, error) {
+	oldKeys, err := configKeys(oldSecret)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	newKeys, err := configKeys(newSecret)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var retired []HistoricKey
+	for _, k := range oldKeys {
+		if len(appendHistoricKey(newKeys, k)) > len(newKeys) {
+			retired = append(retired, k)
+		}
+	}
+
+	return retired, nil
+}
+
+// escrowRetiredKeys stores the keys removed from the encryption config in the key history secret of the cluster,
+// it has to succeed before the config without the keys is persisted, so the keys are never lost
+func (s *Service) escrowRetiredKeys(ctx context.Context, oldSecret v1.Secret, newSecret v1.Secret, status *v1alpha1.RotationStatus) error {
+	retired, err := retiredKeys(oldSecret, newSecret)
+	if err != nil {
+		return microerror.Mask(err)
+	} else if len(retired) == 0 {
+		return nil
+	}
+
+	historySecret, history, err := s.getKeyHistory(ctx)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	// the retired keys were active since the previous rotation replaced its keys,
+	// without history the last rotation or the creation of the config is the best guess
+	var activeFrom time.Time
+	if len(history) == 0 {
+		activeFrom, err = lastKeyRotation(oldSecret)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+	for _, k := range history {
+		if k.ActiveUntil.After(activeFrom) {
+			activeFrom = k.ActiveUntil
+		}
+	}
+	// the retired keys stopped encrypting new data when the rotation added the new key
+	activeUntil := time.Now()
+	if status.StartedAt != nil {
+		activeUntil = status.StartedAt.Time
+	}
+
+	escrowed := len(history)
+	for _, k := range retired {
+		k.ActiveFrom = activeFrom.UTC()
+		k.ActiveUntil = activeUntil.UTC()
+		history = appendHistoricKey(history, k)
+	}
+	if len(history) == escrowed {
+		// keys were already escrowed by a previous attempt of the phase
+		return nil
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if historySecret == nil {
+		historySecret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.KeyHistorySecretName(s.cluster.Name),
+				Namespace: s.cluster.Namespace,
+				Labels: map[string]string{
+					label.Cluster:         s.cluster.Name,
+					label.ManagedBy:       project.Name(),
+					capi.ClusterNameLabel: s.cluster.Name,
+				},
+			},
+			Data: map[string][]byte{KeyHistory: data},
+		}
+		_, err = s.setOwnerReference(historySecret, s.ownerReferenceEnabled())
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		err = s.ctrlClient.Create(ctx, historySecret)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	} else {
+		if historySecret.Data == nil {
+			historySecret.Data = map[string][]byte{}
+		}
+		historySecret.Data[KeyHistory] = data
+		_, err = s.setOwnerReference(historySecret, s.ownerReferenceEnabled())
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		err = s.ctrlClient.Update(ctx, historySecret)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	s.logger.Info(fmt.Sprintf("escrowed %d retired encryption keys to key history secret %s", len(history)-escrowed, historySecret.Name))
+	record.Eventf(s.cluster, "EncryptionKeyEscrowed", "Escrowed %d retired encryption keys to key history secret %s", len(history)-escrowed, historySecret.Name)
+
+	return nil
+}
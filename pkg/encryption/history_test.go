@@ -0,0 +1,119 @@
+package encryption
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+)
+
+func testSecretboxConfig(keys ...string) v1.Secret {
+	config := `kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - secretbox:
+      keys:
+`
+	for _, k := range keys {
+		config += "      - name: " + k + "\n        secret: secret-" + k + "\n"
+	}
+	config += "  - identity: {}\n"
+
+	return v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.Unix(100, 0)},
+		Data:       map[string][]byte{EncryptionProviderConfig: []byte(config)},
+	}
+}
+
+func Test_escrowRetiredKeys(t *testing.T) {
+	ctx := context.Background()
+	s := &Service{
+		cluster:    &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"}},
+		ctrlClient: fake.NewClientBuilder().Build(),
+		logger:     logr.Discard(),
+	}
+
+	firstRotation := metav1.Unix(200, 0)
+	secondRotation := metav1.Unix(300, 0)
+
+	// first rotation retires key1, the escrow is retried with the same result
+	for i := 0; i < 2; i++ {
+		err := s.escrowRetiredKeys(ctx, testSecretboxConfig("key2", "key1"), testSecretboxConfig("key2"), &v1alpha1.RotationStatus{StartedAt: &firstRotation})
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	}
+	// second rotation retires key2
+	err := s.escrowRetiredKeys(ctx, testSecretboxConfig("key3", "key2"), testSecretboxConfig("key3"), &v1alpha1.RotationStatus{StartedAt: &secondRotation})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	// no key is retired if the config did not lose keys
+	err = s.escrowRetiredKeys(ctx, testSecretboxConfig("key3"), testSecretboxConfig("key3"), &v1alpha1.RotationStatus{})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	_, history, err := s.getKeyHistory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []HistoricKey{
+		{Name: "key1", Provider: "secretbox", Secret: "secret-key1", ActiveFrom: time.Unix(100, 0).UTC(), ActiveUntil: time.Unix(200, 0).UTC()},
+		{Name: "key2", Provider: "secretbox", Secret: "secret-key2", ActiveFrom: time.Unix(200, 0).UTC(), ActiveUntil: time.Unix(300, 0).UTC()},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d escrowed keys but got %v", len(expected), history)
+	}
+	for i := range expected {
+		if history[i].Name != expected[i].Name || history[i].Provider != expected[i].Provider || history[i].Secret != expected[i].Secret ||
+			!history[i].ActiveFrom.Equal(expected[i].ActiveFrom) || !history[i].ActiveUntil.Equal(expected[i].ActiveUntil) {
+			t.Fatalf("expected escrowed key %v but got %v", expected[i], history[i])
+		}
+	}
+}
+
+func Test_retiredKeys(t *testing.T) {
+	oldSecret := v1.Secret{
+		Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - secretbox:
+      keys:
+      - name: key1
+        secret: testkey1
+  - aescbc:
+      keys:
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+	}
+	newSecret := *oldSecret.DeepCopy()
+	err := removeOldEncryptionKey(&newSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retired, err := retiredKeys(oldSecret, newSecret)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(retired) != 1 || retired[0].Provider != providerAESCBC || retired[0].Name != "key1" {
+		t.Fatalf("expected legacy aescbc key to be retired but got %v", retired)
+	}
+}
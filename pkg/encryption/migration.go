@@ -0,0 +1,126 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/giantswarm/k8smetadata/pkg/label"
+	"github.com/giantswarm/microerror"
+	storagemigrationv1beta1 "k8s.io/api/storagemigration/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/project"
+)
+
+const storageVersionMigrationResource = "storageversionmigrations"
+
+// storageVersionMigrationAvailable returns true if the workload cluster serves the StorageVersionMigration API
+func storageVersionMigrationAvailable(discoveryClient discovery.DiscoveryInterface) (bool, error) {
+	list, err := discoveryClient.ServerResourcesForGroupVersion(storagemigrationv1beta1.SchemeGroupVersion.String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	for _, r := range list.APIResources {
+		if r.Name == storageVersionMigrationResource {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// storageVersionMigrationName returns the name of the migration of the resource for the rotation started at the given time
+func storageVersionMigrationName(resource schema.GroupResource, startedAt *metav1.Time) string {
+	var started int64
+	if startedAt != nil {
+		started = startedAt.Unix()
+	}
+
+	return fmt.Sprintf("encryption-%s-%d", resource.String(), started)
+}
+
+// migrateResources creates a StorageVersionMigration for every resource in the workload cluster matching the resources of the config,
+// the API server rewrites all objects of a migrated resource without changing them, so their controllers are not triggered.
+// It returns true once all migrations succeeded, the succeeded resources are recorded in the checkpoint of the rotation.
+func (s *Service) migrateResources(ctx context.Context, wcClient ctrlclient.Client, discoveryClient discovery.DiscoveryInterface, resources []string, status *v1alpha1.RotationStatus) (bool, error) {
+	apiResources, err := discoverResources(discoveryClient, resources)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	if status.RewriteCheckpoint == nil {
+		status.RewriteCheckpoint = &v1alpha1.RewriteCheckpoint{}
+	}
+	checkpoint := status.RewriteCheckpoint
+
+	done := true
+	for _, r := range apiResources {
+		resource := r.String()
+		if slices.Contains(checkpoint.CompletedResources, resource) {
+			continue
+		}
+
+		var migration storagemigrationv1beta1.StorageVersionMigration
+		err = wcClient.Get(ctx, ctrlclient.ObjectKey{Name: storageVersionMigrationName(r.GroupResource, status.StartedAt)}, &migration)
+		if apierrors.IsNotFound(err) {
+			migration = storagemigrationv1beta1.StorageVersionMigration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: storageVersionMigrationName(r.GroupResource, status.StartedAt),
+					Labels: map[string]string{
+						label.ManagedBy: project.Name(),
+					},
+				},
+				Spec: storagemigrationv1beta1.StorageVersionMigrationSpec{
+					Resource: metav1.GroupResource{Group: r.Group, Resource: r.Resource},
+				},
+			}
+			err = wcClient.Create(ctx, &migration)
+			if err != nil {
+				return false, microerror.Mask(err)
+			}
+			s.logger.Info(fmt.Sprintf("created storage version migration %s for %s", migration.Name, resource))
+			done = false
+			continue
+		} else if err != nil {
+			return false, microerror.Mask(err)
+		}
+
+		if failed := meta.FindStatusCondition(migration.Status.Conditions, string(storagemigrationv1beta1.MigrationFailed)); failed != nil && failed.Status == metav1.ConditionTrue {
+			// delete the failed migration, so it is created again when the phase is retried
+			err = wcClient.Delete(ctx, &migration)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return false, microerror.Mask(err)
+			}
+			return false, microerror.Mask(fmt.Errorf("storage version migration %s of %s failed: %s", migration.Name, resource, failed.Message))
+		}
+
+		if meta.IsStatusConditionTrue(migration.Status.Conditions, string(storagemigrationv1beta1.MigrationSucceeded)) {
+			checkpoint.CompletedResources = append(checkpoint.CompletedResources, resource)
+			s.logger.Info(fmt.Sprintf("storage version migration %s of %s succeeded", migration.Name, resource))
+			continue
+		}
+
+		s.logger.Info(fmt.Sprintf("waiting for storage version migration %s of %s", migration.Name, resource))
+		done = false
+	}
+
+	if done {
+		// all resources are migrated, remove the migrations of the operator from the workload cluster
+		err = wcClient.DeleteAllOf(ctx, &storagemigrationv1beta1.StorageVersionMigration{}, ctrlclient.MatchingLabels{label.ManagedBy: project.Name()})
+		if err != nil {
+			return false, microerror.Mask(err)
+		}
+	}
+
+	return done, nil
+}
@@ -0,0 +1,156 @@
+package encryption
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	storagemigrationv1beta1 "k8s.io/api/storagemigration/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+)
+
+func Test_storageVersionMigrationAvailable(t *testing.T) {
+	discoveryClient := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}
+	available, err := storageVersionMigrationAvailable(discoveryClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available {
+		t.Fatalf("expected storage version migration not to be available")
+	}
+
+	discoveryClient.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: storagemigrationv1beta1.SchemeGroupVersion.String(),
+			APIResources: []metav1.APIResource{{Name: storageVersionMigrationResource, Kind: "StorageVersionMigration"}},
+		},
+	}
+	available, err = storageVersionMigrationAvailable(discoveryClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Fatalf("expected storage version migration to be available")
+	}
+}
+
+func Test_migrateResources(t *testing.T) {
+	ctx := context.Background()
+	wcClient := fake.NewClientBuilder().WithStatusSubresource(&storagemigrationv1beta1.StorageVersionMigration{}).Build()
+	discoveryClient := &preferredResourcesDiscovery{
+		resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "configmaps", Kind: "ConfigMap", Verbs: []string{"list", "patch"}},
+					{Name: "secrets", Kind: "Secret", Verbs: []string{"list", "patch"}},
+				},
+			},
+		},
+	}
+
+	s := &Service{
+		cluster: &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"}},
+		logger:  logr.Discard(),
+	}
+	startedAt := metav1.Unix(1700000000, 0)
+	status := &v1alpha1.RotationStatus{StartedAt: &startedAt}
+
+	// first run creates the migrations
+	done, err := s.migrateResources(ctx, wcClient, discoveryClient, []string{"secrets", "configmaps"}, status)
+	if err != nil {
+		t.Fatalf("failed to migrate resources %s", err)
+	}
+	if done {
+		t.Fatalf("expected migrations to be in progress")
+	}
+
+	var migrations storagemigrationv1beta1.StorageVersionMigrationList
+	err = wcClient.List(ctx, &migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations.Items) != 2 {
+		t.Fatalf("expected 2 migrations but got %d", len(migrations.Items))
+	}
+
+	// the secrets migration succeeds
+	var migration storagemigrationv1beta1.StorageVersionMigration
+	err = wcClient.Get(ctx, ctrlclient.ObjectKey{Name: storageVersionMigrationName(schema.GroupResource{Resource: "secrets"}, &startedAt)}, &migration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.SetStatusCondition(&migration.Status.Conditions, metav1.Condition{Type: string(storagemigrationv1beta1.MigrationSucceeded), Status: metav1.ConditionTrue, Reason: "Succeeded"})
+	err = wcClient.Status().Update(ctx, &migration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err = s.migrateResources(ctx, wcClient, discoveryClient, []string{"secrets", "configmaps"}, status)
+	if err != nil {
+		t.Fatalf("failed to migrate resources %s", err)
+	}
+	if done {
+		t.Fatalf("expected configmaps migration to be in progress")
+	}
+	if !reflect.DeepEqual(status.RewriteCheckpoint.CompletedResources, []string{"secrets"}) {
+		t.Fatalf("expected secrets to be completed but got %v", status.RewriteCheckpoint.CompletedResources)
+	}
+
+	// the configmaps migration fails and is deleted to be created again
+	err = wcClient.Get(ctx, ctrlclient.ObjectKey{Name: storageVersionMigrationName(schema.GroupResource{Resource: "configmaps"}, &startedAt)}, &migration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.SetStatusCondition(&migration.Status.Conditions, metav1.Condition{Type: string(storagemigrationv1beta1.MigrationFailed), Status: metav1.ConditionTrue, Reason: "Failed", Message: "timeout"})
+	err = wcClient.Status().Update(ctx, &migration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.migrateResources(ctx, wcClient, discoveryClient, []string{"secrets", "configmaps"}, status)
+	if err == nil {
+		t.Fatalf("expected failed migration to return an error")
+	}
+
+	// the recreated configmaps migration succeeds
+	done, err = s.migrateResources(ctx, wcClient, discoveryClient, []string{"secrets", "configmaps"}, status)
+	if err != nil || done {
+		t.Fatalf("expected configmaps migration to be created again, done %t error %v", done, err)
+	}
+	err = wcClient.Get(ctx, ctrlclient.ObjectKey{Name: storageVersionMigrationName(schema.GroupResource{Resource: "configmaps"}, &startedAt)}, &migration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.SetStatusCondition(&migration.Status.Conditions, metav1.Condition{Type: string(storagemigrationv1beta1.MigrationSucceeded), Status: metav1.ConditionTrue, Reason: "Succeeded"})
+	err = wcClient.Status().Update(ctx, &migration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err = s.migrateResources(ctx, wcClient, discoveryClient, []string{"secrets", "configmaps"}, status)
+	if err != nil {
+		t.Fatalf("failed to migrate resources %s", err)
+	}
+	if !done {
+		t.Fatalf("expected all migrations to be done")
+	}
+
+	err = wcClient.List(ctx, &migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations.Items) != 0 {
+		t.Fatalf("expected migrations to be deleted but got %d", len(migrations.Items))
+	}
+}
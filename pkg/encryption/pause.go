@@ -0,0 +1,82 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/pkg/conditions"
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+)
+
+const (
+	// PausedAnnotation on the Cluster pauses the encryption reconciliation of the cluster without pausing Cluster API,
+	// no key is rotated, no hasher app deployed and no resource rewritten until it is removed.
+	PausedAnnotation = "encryption.giantswarm.io/paused"
+)
+
+// pausedReason returns the condition reason why the cluster is paused, it is empty if the cluster is not paused
+func pausedReason(cluster *capi.Cluster) string {
+	if _, ok := cluster.Annotations[PausedAnnotation]; ok {
+		return conditions.EncryptionPausedReason
+	}
+	if _, ok := cluster.Annotations[capi.PausedAnnotation]; ok || cluster.Spec.Paused {
+		return conditions.ClusterPausedReason
+	}
+
+	return ""
+}
+
+// IsPaused returns true if the cluster is paused by Cluster API with spec.paused or the cluster.x-k8s.io/paused annotation,
+// or only for the operator with the encryption.giantswarm.io/paused annotation
+func IsPaused(cluster *capi.Cluster) bool {
+	return pausedReason(cluster) != ""
+}
+
+// ReportStatus reports the conditions, the metrics and the rotation status of a paused cluster,
+// the encryption provider config secret and the workload cluster are not changed
+func (s *Service) ReportStatus() error {
+	ctx := context.TODO()
+
+	reason := pausedReason(s.cluster)
+	if reason == conditions.EncryptionPausedReason {
+		conditions.MarkTrue(s.cluster, conditions.EncryptionReconciliationPaused, reason, "encryption reconciliation is paused by the %s annotation", PausedAnnotation)
+	} else {
+		conditions.MarkTrue(s.cluster, conditions.EncryptionReconciliationPaused, reason, "encryption reconciliation is paused with the cluster")
+	}
+
+	var encryptionProviderSecret v1.Secret
+	err := s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{
+		Name:      key.SecretName(s.cluster.Name),
+		Namespace: s.cluster.Namespace,
+	}, &encryptionProviderSecret)
+	if apierrors.IsNotFound(err) {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionConfigReady, conditions.EncryptionConfigMissingReason, capi.ConditionSeverityWarning,
+			"encryption provider config secret %s does not exist, it is not created while the cluster is paused", key.SecretName(s.cluster.Name))
+		return nil
+	} else if err != nil {
+		conditions.MarkFalse(s.cluster, conditions.EncryptionConfigReady, conditions.EncryptionConfigFailedReason, capi.ConditionSeverityError, "failed to get encryption provider config secret: %s", err)
+		return microerror.Mask(err)
+	}
+
+	status, err := getRotationStatus(encryptionProviderSecret)
+	if err != nil {
+		s.logger.Error(err, "failed to read rotation status from encryption provider secret")
+	} else if status.Phase != "" {
+		err = s.reportRotationStatus(ctx, status)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	s.setConditions(encryptionProviderSecret)
+	s.updateMetrics(encryptionProviderSecret)
+	s.logger.Info(fmt.Sprintf("encryption reconciliation is paused (%s), only reporting status", reason))
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package encryption
+
+import (
+	"context"
+	"testing"
+
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	capiconditions "sigs.k8s.io/cluster-api/util/deprecated/v1beta1/conditions"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/conditions"
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+)
+
+func Test_IsPaused(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cluster  *capi.Cluster
+		expected bool
+	}{
+		{
+			name:    "case 0: not paused",
+			cluster: &capi.Cluster{},
+		},
+		{
+			name:     "case 1: spec.paused",
+			cluster:  &capi.Cluster{Spec: capi.ClusterSpec{Paused: true}},
+			expected: true,
+		},
+		{
+			name:     "case 2: cluster api paused annotation",
+			cluster:  &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{capi.PausedAnnotation: ""}}},
+			expected: true,
+		},
+		{
+			name:     "case 3: operator paused annotation",
+			cluster:  &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{PausedAnnotation: "true"}}},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			paused := IsPaused(tc.cluster)
+			if paused != tc.expected {
+				t.Fatalf("expected paused %t but got %t", tc.expected, paused)
+			}
+		})
+	}
+}
+
+func Test_ReportStatus(t *testing.T) {
+	ctx := context.Background()
+	cluster := &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test", Annotations: map[string]string{PausedAnnotation: "true"}}}
+
+	secret := testSecretboxConfig("key2", "key1")
+	secret.ObjectMeta = metav1.ObjectMeta{
+		Name:      key.SecretName("test"),
+		Namespace: "org-test",
+		Annotations: map[string]string{
+			annotation.EncryptionForceRotation: "true",
+			v1alpha1.RotationStatusAnnotation:  `{"phase":"WaitingForControlPlane"}`,
+		},
+	}
+	s := testRestoreService(t, cluster, &secret)
+
+	err := s.ReportStatus()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	var reported v1.Secret
+	err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKeyFromObject(&secret), &reported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reported.ResourceVersion != "999" {
+		t.Fatalf("expected paused cluster secret to be unchanged but got resource version %s", reported.ResourceVersion)
+	}
+
+	if !capiconditions.IsTrue(cluster, conditions.EncryptionReconciliationPaused) {
+		t.Fatalf("expected %s condition to be true", conditions.EncryptionReconciliationPaused)
+	}
+	if !capiconditions.IsTrue(cluster, conditions.EncryptionKeyRotationInProgress) {
+		t.Fatalf("expected %s condition to report the rotation", conditions.EncryptionKeyRotationInProgress)
+	}
+}
+
+func Test_ReportStatus_missingSecret(t *testing.T) {
+	ctx := context.Background()
+	cluster := &capi.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"},
+		Spec:       capi.ClusterSpec{Paused: true},
+	}
+	s := testRestoreService(t, cluster)
+
+	err := s.ReportStatus()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{Name: key.SecretName("test"), Namespace: "org-test"}, &v1.Secret{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected no encryption provider config secret for paused cluster but got %v", err)
+	}
+	if capiconditions.IsTrue(cluster, conditions.EncryptionConfigReady) {
+		t.Fatalf("expected %s condition to be false", conditions.EncryptionConfigReady)
+	}
+}
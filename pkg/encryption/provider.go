@@ -0,0 +1,250 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	configv1 "github.com/giantswarm/encryption-provider-operator/pkg/config"
+)
+
+const (
+	// AESGCMKeyLength represents the 32 bytes length of an AES-256 key used by the AES-GCM provider.
+	AESGCMKeyLength = 32
+
+	// KMSAPIVersion is the version of the KMS plugin API used for the kms provider.
+	KMSAPIVersion = "v2"
+
+	providerAESCBC   = "aescbc"
+	providerIdentity = "identity"
+)
+
+// KMSConfig is the KMS v2 plugin running on the control plane nodes of clusters using the kms provider.
+type KMSConfig struct {
+	// Name is the unique name of the KMS plugin in the encryption config.
+	Name string
+	// Endpoint is the gRPC listening address of the KMS plugin, e.g. unix:///var/run/kmsplugin/socket.sock.
+	Endpoint string
+	// Timeout for gRPC calls to the KMS plugin, the kube-apiserver default is used if zero.
+	Timeout time.Duration
+}
+
+// keyLength returns the length of a newly generated key for the provider
+func keyLength(provider v1alpha1.ProviderType) (int, error) {
+	switch provider {
+	case v1alpha1.ProviderSecretbox:
+		return Poly1305KeyLength, nil
+	case v1alpha1.ProviderAESGCM:
+		return AESGCMKeyLength, nil
+	default:
+		return 0, microerror.Mask(fmt.Errorf("encryption provider %q does not use locally generated keys", provider))
+	}
+}
+
+// newKMSConfiguration builds the KMS v2 provider configuration
+func newKMSConfiguration(kms KMSConfig) (configv1.ProviderConfiguration, error) {
+	if kms.Name == "" || kms.Endpoint == "" {
+		return configv1.ProviderConfiguration{}, microerror.Mask(fmt.Errorf("kms encryption provider requires the name and the endpoint of the KMS plugin"))
+	}
+
+	c := &configv1.KMSConfiguration{
+		APIVersion: KMSAPIVersion,
+		Name:       kms.Name,
+		Endpoint:   kms.Endpoint,
+	}
+	if kms.Timeout > 0 {
+		c.Timeout = &configv1.Duration{Duration: kms.Timeout}
+	}
+
+	return configv1.ProviderConfiguration{KMS: c}, nil
+}
+
+// newProviderKey generates a new random key with the length required by the provider
+func newProviderKey(provider v1alpha1.ProviderType) (string, error) {
+	length, err := keyLength(provider)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return newRandomKey(length)
+}
+
+// newProviderConfiguration builds the provider configuration with a single key
+func newProviderConfiguration(provider v1alpha1.ProviderType, key configv1.Key) (configv1.ProviderConfiguration, error) {
+	switch provider {
+	case v1alpha1.ProviderSecretbox:
+		return configv1.ProviderConfiguration{
+			Secretbox: &configv1.SecretboxConfiguration{Keys: []configv1.Key{key}},
+		}, nil
+	case v1alpha1.ProviderAESGCM:
+		return configv1.ProviderConfiguration{
+			AESGCM: &configv1.AESConfiguration{Keys: []configv1.Key{key}},
+		}, nil
+	default:
+		return configv1.ProviderConfiguration{}, microerror.Mask(fmt.Errorf("unsupported encryption provider %q", provider))
+	}
+}
+
+// providerType returns the name of the provider configured in the provider configuration
+func providerType(p configv1.ProviderConfiguration) string {
+	switch {
+	case p.AESGCM != nil:
+		return string(v1alpha1.ProviderAESGCM)
+	case p.AESCBC != nil:
+		return providerAESCBC
+	case p.Secretbox != nil:
+		return string(v1alpha1.ProviderSecretbox)
+	case p.KMS != nil:
+		return string(v1alpha1.ProviderKMS)
+	default:
+		return providerIdentity
+	}
+}
+
+// providerKeys returns the keys of the provider configuration, nil for providers without local keys
+func providerKeys(p configv1.ProviderConfiguration) *[]configv1.Key {
+	switch {
+	case p.AESGCM != nil:
+		return &p.AESGCM.Keys
+	case p.AESCBC != nil:
+		return &p.AESCBC.Keys
+	case p.Secretbox != nil:
+		return &p.Secretbox.Keys
+	default:
+		return nil
+	}
+}
+
+// currentProvider returns the provider used to write new data, which is always the first one
+func currentProvider(secret v1.Secret) (configv1.ProviderConfiguration, error) {
+	var ec configv1.EncryptionConfiguration
+	err := yaml.Unmarshal(secret.Data[EncryptionProviderConfig], &ec)
+	if err != nil {
+		return configv1.ProviderConfiguration{}, microerror.Mask(err)
+	}
+
+	for _, rc := range ec.Resources {
+		if len(rc.Providers) > 0 && !isDecryptingResourceConfiguration(rc) {
+			return rc.Providers[0], nil
+		}
+	}
+
+	return configv1.ProviderConfiguration{}, microerror.Mask(fmt.Errorf("encryption config does not contain any provider"))
+}
+
+// addKMSProvider adds the KMS provider at the first position, a KMS provider with the same name is replaced
+// as the names of KMS providers must be unique in the config
+func addKMSProvider(secret *v1.Secret, kmsProvider configv1.ProviderConfiguration) error {
+	var ec configv1.EncryptionConfiguration
+	err := yaml.Unmarshal(secret.Data[EncryptionProviderConfig], &ec)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	for i := range ec.Resources {
+		if isDecryptingResourceConfiguration(ec.Resources[i]) {
+			continue
+		}
+
+		providers := []configv1.ProviderConfiguration{kmsProvider}
+		for _, p := range ec.Resources[i].Providers {
+			if p.KMS != nil && p.KMS.Name == kmsProvider.KMS.Name {
+				continue
+			}
+			providers = append(providers, p)
+		}
+		ec.Resources[i].Providers = providers
+	}
+
+	o, err := yaml.Marshal(ec)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	secret.Data[EncryptionProviderConfig] = o
+	return nil
+}
+
+// newProviderConfiguration builds the configuration of the cluster provider, for providers with local keys a new key is generated
+// and escrowed before it is used
+func (s *Service) newProviderConfiguration(ctx context.Context) (configv1.ProviderConfiguration, error) {
+	if s.provider() == v1alpha1.ProviderKMS {
+		return newKMSConfiguration(s.kms)
+	}
+
+	newKey, err := newProviderKey(s.provider())
+	if err != nil {
+		return configv1.ProviderConfiguration{}, microerror.Mask(err)
+	}
+
+	err = s.escrowKey(ctx, s.newEscrowedKey(keyName(1), string(s.provider()), newKey))
+	if err != nil {
+		return configv1.ProviderConfiguration{}, microerror.Mask(err)
+	}
+
+	return newProviderConfiguration(s.provider(), configv1.Key{Name: keyName(1), Secret: newKey})
+}
+
+// addNewProvider adds a new key of the cluster provider to the config, for kms the KMS provider is added instead,
+// the new key is escrowed before the config is persisted
+func (s *Service) addNewProvider(ctx context.Context, secret *v1.Secret) error {
+	if s.provider() == v1alpha1.ProviderKMS {
+		kmsProvider, err := newKMSConfiguration(s.kms)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		return addKMSProvider(secret, kmsProvider)
+	}
+
+	newKey, err := newProviderKey(s.provider())
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = addNewEncryptionKey(secret, s.provider(), newKey)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	current, err := currentProvider(*secret)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	for _, k := range *providerKeys(current) {
+		if k.Secret == newKey {
+			return s.escrowKey(ctx, s.newEscrowedKey(k.Name, string(s.provider()), newKey))
+		}
+	}
+
+	// the config already had a new key which was not pruned yet
+	return nil
+}
+
+// providerChanged returns true if the provider of the config differs from the cluster provider,
+// legacy aescbc configs keep being migrated by the regular rotation unless the cluster moves to kms
+func (s *Service) providerChanged(secret v1.Secret) (bool, error) {
+	current, err := currentProvider(secret)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	currentType := providerType(current)
+	switch {
+	case currentType == providerAESCBC && s.provider() != v1alpha1.ProviderKMS:
+		return false, nil
+	case currentType == providerIdentity:
+		return false, nil
+	case currentType != string(s.provider()):
+		return true, nil
+	case current.KMS != nil:
+		// the KMS plugin of the cluster was changed
+		return current.KMS.Name != s.kms.Name || current.KMS.Endpoint != s.kms.Endpoint, nil
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,123 @@
+package encryption
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+)
+
+func Test_addKMSProvider(t *testing.T) {
+	testCases := []struct {
+		name           string
+		kms            KMSConfig
+		secret         v1.Secret
+		expectedSecret v1.Secret
+	}{
+		{
+			name: "case 0: migrate config with secretbox provider to kms",
+			kms:  KMSConfig{Name: "vault", Endpoint: "unix:///var/run/kmsplugin/socket.sock", Timeout: 5 * time.Second},
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - secretbox:
+      keys:
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - kms:
+      apiVersion: v2
+      name: vault
+      endpoint: unix:///var/run/kmsplugin/socket.sock
+      timeout: 5s
+  - secretbox:
+      keys:
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+		},
+		{
+			name: "case 1: replace kms provider with the same name",
+			kms:  KMSConfig{Name: "vault", Endpoint: "unix:///var/run/kmsplugin/vault.sock"},
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - secretbox:
+      keys:
+      - name: key1
+        secret: testkey1
+  - kms:
+      apiVersion: v2
+      name: vault
+      endpoint: unix:///var/run/kmsplugin/socket.sock
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - kms:
+      apiVersion: v2
+      name: vault
+      endpoint: unix:///var/run/kmsplugin/vault.sock
+  - secretbox:
+      keys:
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		tc := tc
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			kmsProvider, err := newKMSConfiguration(tc.kms)
+			if err != nil {
+				t.Fatalf("%s : failed to create kms provider %s", tc.name, err)
+			}
+
+			err = addKMSProvider(&tc.secret, kmsProvider)
+			if err != nil {
+				t.Fatalf("%s : failed to add kms provider to config %s", tc.name, err)
+			}
+
+			if diff := cmp.Diff(string(tc.expectedSecret.Data[EncryptionProviderConfig]), string(tc.secret.Data[EncryptionProviderConfig])); diff != "" {
+				t.Fatalf("%s : secrets are not equal %s", tc.name, diff)
+			}
+		})
+	}
+}
+
+func Test_newKMSConfiguration(t *testing.T) {
+	_, err := newKMSConfiguration(KMSConfig{Endpoint: "unix:///var/run/kmsplugin/socket.sock"})
+	if err == nil {
+		t.Fatalf("expected error for kms plugin without name")
+	}
+}
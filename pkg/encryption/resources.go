@@ -0,0 +1,388 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	"github.com/giantswarm/microerror"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	configv1 "github.com/giantswarm/encryption-provider-operator/pkg/config"
+	"github.com/giantswarm/encryption-provider-operator/pkg/metrics"
+)
+
+// RewriteConfig limits the load on the workload cluster API while the encrypted resources are rewritten.
+type RewriteConfig struct {
+	// PageSize is the number of objects listed per request.
+	PageSize int64
+	// Workers is the number of objects patched concurrently.
+	Workers int
+	// QPS is the maximal number of requests per second to the workload cluster API.
+	QPS float32
+	// Burst is the maximal burst of requests to the workload cluster API.
+	Burst int
+}
+
+// wildcard is used in resource names to match all resources of a group or all groups, e.g. *.apps or *.*
+const wildcard = "*"
+
+var resourceNameRegexp = regexp.MustCompile(`^(\*|[a-z0-9]([-a-z0-9]*[a-z0-9])?)(\.(\*|[a-z0-9]([-a-z0-9.]*[a-z0-9])?)?)?$`)
+
+// ValidateResources checks the resources to encrypt have the format of the resources in the EncryptionConfiguration,
+// either a resource name with an optional group (secrets, deployments.apps) or a wildcard for a group (*.apps, *.) or all groups (*.*)
+func ValidateResources(resources []string) error {
+	if len(resources) == 0 {
+		return microerror.Mask(fmt.Errorf("at least one resource has to be encrypted"))
+	}
+
+	seen := map[string]bool{}
+	for _, r := range resources {
+		if !resourceNameRegexp.MatchString(r) {
+			return microerror.Mask(fmt.Errorf("resource %q is not a valid resource name, use resource.group, *.group or *.*", r))
+		}
+		if r == wildcard || (strings.HasSuffix(r, "."+wildcard) && !strings.HasPrefix(r, wildcard+".")) || (strings.HasSuffix(r, ".") && r != wildcard+".") {
+			return microerror.Mask(fmt.Errorf("resource %q is not a valid wildcard, use *.group or *.*", r))
+		}
+		if seen[r] {
+			return microerror.Mask(fmt.Errorf("resource %q is listed more than once", r))
+		}
+		seen[r] = true
+	}
+
+	return nil
+}
+
+// isDecryptingResourceConfiguration returns true for resource configurations added to decrypt resources
+// which are not encrypted anymore, identity is the first provider so rewritten resources are stored unencrypted
+func isDecryptingResourceConfiguration(rc configv1.ResourceConfiguration) bool {
+	return len(rc.Providers) > 0 && rc.Providers[0].Identity != nil
+}
+
+// encryptedResources returns the resources of the config which are encrypted with the current provider
+func encryptedResources(ec configv1.EncryptionConfiguration) []string {
+	var resources []string
+	for _, rc := range ec.Resources {
+		if isDecryptingResourceConfiguration(rc) {
+			continue
+		}
+		resources = append(resources, rc.Resources...)
+	}
+
+	return resources
+}
+
+// configResources returns all resources of the config, including the resources which are decrypted
+func configResources(secret v1.Secret) ([]string, error) {
+	var ec configv1.EncryptionConfiguration
+	err := yaml.Unmarshal(secret.Data[EncryptionProviderConfig], &ec)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var resources []string
+	for _, rc := range ec.Resources {
+		for _, r := range rc.Resources {
+			if !slices.Contains(resources, r) {
+				resources = append(resources, r)
+			}
+		}
+	}
+
+	return resources, nil
+}
+
+// resourcesChanged returns true if the encrypted resources of the config differ from the desired resources
+func resourcesChanged(secret v1.Secret, resources []string) (bool, error) {
+	var ec configv1.EncryptionConfiguration
+	err := yaml.Unmarshal(secret.Data[EncryptionProviderConfig], &ec)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	current := encryptedResources(ec)
+	for _, r := range resources {
+		if !slices.Contains(current, r) {
+			return true, nil
+		}
+	}
+	for _, r := range current {
+		if !slices.Contains(resources, r) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// setEncryptedResources changes the encrypted resources of the config,
+// new resources are added to the first resource configuration and encrypted with its providers,
+// removed resources are moved to a new resource configuration with identity as the first provider followed by
+// the old providers, so they are still readable until they are rewritten unencrypted and the configuration is pruned
+func setEncryptedResources(secret *v1.Secret, resources []string) error {
+	var ec configv1.EncryptionConfiguration
+	err := yaml.Unmarshal(secret.Data[EncryptionProviderConfig], &ec)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	current := encryptedResources(ec)
+
+	var decrypting []configv1.ResourceConfiguration
+	var encrypting []configv1.ResourceConfiguration
+	var providers []configv1.ProviderConfiguration
+	for _, rc := range ec.Resources {
+		if isDecryptingResourceConfiguration(rc) {
+			// resources which are encrypted again are not decrypted anymore
+			rc.Resources = slices.DeleteFunc(rc.Resources, func(r string) bool { return slices.Contains(resources, r) })
+			if len(rc.Resources) > 0 {
+				decrypting = append(decrypting, rc)
+			}
+			continue
+		}
+		if providers == nil {
+			providers = rc.Providers
+		}
+
+		var kept, removed []string
+		for _, r := range rc.Resources {
+			if slices.Contains(resources, r) {
+				kept = append(kept, r)
+			} else {
+				removed = append(removed, r)
+			}
+		}
+
+		if len(removed) > 0 {
+			decryptingProviders := []configv1.ProviderConfiguration{{Identity: &configv1.IdentityConfiguration{}}}
+			for _, p := range rc.Providers {
+				if p.KMS != nil {
+					// names of KMS providers must be unique in the whole config
+					return microerror.Mask(fmt.Errorf("resources %v can not be removed from the encryption config as they are encrypted by the kms provider %s", removed, p.KMS.Name))
+				}
+				if p.Identity == nil {
+					decryptingProviders = append(decryptingProviders, p)
+				}
+			}
+			decrypting = append(decrypting, configv1.ResourceConfiguration{Resources: removed, Providers: decryptingProviders})
+		}
+		if len(kept) > 0 {
+			rc.Resources = kept
+			encrypting = append(encrypting, rc)
+		}
+	}
+
+	var added []string
+	for _, r := range resources {
+		if !slices.Contains(current, r) {
+			added = append(added, r)
+		}
+	}
+	if len(added) > 0 {
+		if len(encrypting) == 0 {
+			if providers == nil {
+				return microerror.Mask(fmt.Errorf("encryption config does not contain any provider"))
+			}
+			encrypting = append(encrypting, configv1.ResourceConfiguration{Providers: providers})
+		}
+		encrypting[0].Resources = append(encrypting[0].Resources, added...)
+	}
+
+	// decrypting configurations go first, so they are not masked by wildcards of the encrypted resources
+	ec.Resources = append(decrypting, encrypting...)
+
+	o, err := yaml.Marshal(ec)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	secret.Data[EncryptionProviderConfig] = o
+	return nil
+}
+
+// apiResource is a resource discovered in the workload cluster
+type apiResource struct {
+	schema.GroupResource
+	Kind schema.GroupVersionKind
+}
+
+// resolveResources returns all resources in the discovered api resources matching the resources of the config,
+// only resources which can be listed and patched are returned
+func resolveResources(apiResourceLists []*metav1.APIResourceList, resources []string) []apiResource {
+	var resolved []apiResource
+	seen := map[schema.GroupResource]bool{}
+
+	for _, list := range apiResourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+
+		for _, r := range list.APIResources {
+			// skip subresources like secrets/status
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			if !slices.Contains(r.Verbs, "list") || !slices.Contains(r.Verbs, "patch") {
+				continue
+			}
+
+			gr := schema.GroupResource{Group: gv.Group, Resource: r.Name}
+			if seen[gr] || !matchesAnyResource(gr, resources) {
+				continue
+			}
+			seen[gr] = true
+			resolved = append(resolved, apiResource{GroupResource: gr, Kind: gv.WithKind(r.Kind)})
+		}
+	}
+
+	return resolved
+}
+
+// discoverResources returns the resources in the workload cluster matching the resources of the config,
+// it fails if the discovery of a group failed which may contain a configured resource, so no resource is skipped
+func discoverResources(discoveryClient discovery.DiscoveryInterface, resources []string) ([]apiResource, error) {
+	apiResourceLists, err := discoveryClient.ServerPreferredResources()
+	var groupErr *discovery.ErrGroupDiscoveryFailed
+	if errors.As(err, &groupErr) {
+		for gv, gvErr := range groupErr.Groups {
+			if matchesAnyGroup(gv.Group, resources) {
+				return nil, microerror.Mask(fmt.Errorf("failed to discover resources of group version %s: %w", gv, gvErr))
+			}
+		}
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return resolveResources(apiResourceLists, resources), nil
+}
+
+func matchesAnyGroup(group string, resources []string) bool {
+	for _, r := range resources {
+		_, g, _ := strings.Cut(r, ".")
+		if g == wildcard || g == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAnyResource(gr schema.GroupResource, resources []string) bool {
+	for _, r := range resources {
+		name, group, _ := strings.Cut(r, ".")
+		if (name == wildcard || name == gr.Resource) && (group == wildcard || group == gr.Group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewriteResources will load all objects of the resources page by page from the workload cluster and patch their metadata
+// so they are stored again in etcd, encrypted with the first provider of the config,
+// the progress is recorded in the checkpoint which is saved after every page, so an interrupted rewrite resumes from there
+func (s *Service) rewriteResources(ctx context.Context, wcClient ctrlclient.Client, discoveryClient discovery.DiscoveryInterface, resources []string, checkpoint *v1alpha1.RewriteCheckpoint, saveCheckpoint func() error) error {
+	apiResources, err := discoverResources(discoveryClient, resources)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	timestamp := time.Now().Format(time.RFC3339)
+
+	for _, r := range apiResources {
+		resource := r.String()
+		if slices.Contains(checkpoint.CompletedResources, resource) {
+			continue
+		}
+		if checkpoint.Resource != resource {
+			checkpoint.Resource = resource
+			checkpoint.Continue = ""
+		}
+
+		for {
+			var list metav1.PartialObjectMetadataList
+			list.SetGroupVersionKind(r.Kind.GroupVersion().WithKind(r.Kind.Kind + "List"))
+			err = wcClient.List(ctx, &list, ctrlclient.Limit(s.rewrite.PageSize), ctrlclient.Continue(checkpoint.Continue))
+			if checkpoint.Continue != "" && (apierrors.IsResourceExpired(err) || apierrors.IsGone(err)) {
+				// the continue token expired, start the resource again from the first page
+				s.logger.Info(fmt.Sprintf("continue token for %s expired, restarting rewrite of the resource", resource))
+				checkpoint.Continue = ""
+				continue
+			} else if err != nil {
+				return microerror.Mask(err)
+			}
+
+			rewritten, err := s.patchObjects(ctx, wcClient, r.Kind, list.Items, timestamp)
+			metrics.AddRewrittenResources(s.cluster.Namespace, s.cluster.Name, resource, rewritten)
+			checkpoint.Rewritten += int64(rewritten)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+
+			checkpoint.Continue = list.Continue
+			if checkpoint.Continue == "" {
+				checkpoint.CompletedResources = append(checkpoint.CompletedResources, resource)
+				checkpoint.Resource = ""
+			}
+			err = saveCheckpoint()
+			if err != nil {
+				return microerror.Mask(err)
+			}
+
+			if checkpoint.Continue == "" {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// patchObjects patches the rewrite annotation on the objects with a bounded number of concurrent workers
+func (s *Service) patchObjects(ctx context.Context, wcClient ctrlclient.Client, gvk schema.GroupVersionKind, objects []metav1.PartialObjectMetadata, timestamp string) (int, error) {
+	var rewritten atomic.Int64
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.rewrite.Workers)
+	for i := range objects {
+		obj := &objects[i]
+		g.Go(func() error {
+			obj.SetGroupVersionKind(gvk)
+			patch := ctrlclient.MergeFrom(obj.DeepCopy())
+
+			annotations := obj.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[annotation.EncryptionRewriteTimestamp] = timestamp
+			obj.SetAnnotations(annotations)
+
+			err := wcClient.Patch(ctx, obj, patch)
+			if apierrors.IsNotFound(err) {
+				// object was deleted just ignore and fall thru
+				return nil
+			} else if err != nil {
+				return microerror.Mask(err)
+			}
+			rewritten.Add(1)
+			return nil
+		})
+	}
+	err := g.Wait()
+
+	return int(rewritten.Load()), err
+}
@@ -0,0 +1,465 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+)
+
+func Test_ValidateResources(t *testing.T) {
+	testCases := []struct {
+		name      string
+		resources []string
+		valid     bool
+	}{
+		{name: "case 0: secrets", resources: []string{"secrets"}, valid: true},
+		{name: "case 1: resources with group and wildcards", resources: []string{"secrets", "configmaps", "deployments.apps", "*.cert-manager.io", "*.", "*.*"}, valid: true},
+		{name: "case 2: empty list", resources: []string{}, valid: false},
+		{name: "case 3: bare wildcard", resources: []string{"*"}, valid: false},
+		{name: "case 4: wildcard group for a single resource", resources: []string{"secrets.*"}, valid: false},
+		{name: "case 5: upper case", resources: []string{"Secrets"}, valid: false},
+		{name: "case 6: duplicate resource", resources: []string{"secrets", "secrets"}, valid: false},
+		{name: "case 7: trailing dot", resources: []string{"secrets."}, valid: false},
+	}
+
+	for i, tc := range testCases {
+		tc := tc
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := ValidateResources(tc.resources)
+			if tc.valid && err != nil {
+				t.Fatalf("%s : expected resources to be valid but got %s", tc.name, err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf("%s : expected resources to be invalid", tc.name)
+			}
+		})
+	}
+}
+
+func Test_setEncryptedResources(t *testing.T) {
+	testCases := []struct {
+		name           string
+		resources      []string
+		secret         v1.Secret
+		expectedSecret v1.Secret
+		expectedError  bool
+	}{
+		{
+			name:      "case 0: add configmaps and cert-manager resources",
+			resources: []string{"secrets", "configmaps", "*.cert-manager.io"},
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  providers:
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  - configmaps
+  - '*.cert-manager.io'
+  providers:
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+		},
+		{
+			name:      "case 1: remove configmaps, they are decrypted until the old key is pruned",
+			resources: []string{"secrets"},
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  - configmaps
+  providers:
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - configmaps
+  providers:
+  - identity: {}
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+      - name: key1
+        secret: testkey1
+- resources:
+  - secrets
+  providers:
+  - secretbox:
+      keys:
+      - name: key2
+        secret: testkey2
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+		},
+		{
+			name:      "case 2: encrypt resource again which is decrypted",
+			resources: []string{"secrets", "configmaps"},
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - configmaps
+  providers:
+  - identity: {}
+  - secretbox:
+      keys:
+      - name: key1
+        secret: testkey1
+- resources:
+  - secrets
+  providers:
+  - secretbox:
+      keys:
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+			expectedSecret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  - configmaps
+  providers:
+  - secretbox:
+      keys:
+      - name: key1
+        secret: testkey1
+  - identity: {}
+`)},
+			},
+		},
+		{
+			name:      "case 3: removing resources encrypted by kms is not supported",
+			resources: []string{"secrets"},
+			secret: v1.Secret{
+				Data: map[string][]byte{EncryptionProviderConfig: []byte(`kind: EncryptionConfiguration
+apiVersion: v1
+resources:
+- resources:
+  - secrets
+  - configmaps
+  providers:
+  - kms:
+      apiVersion: v2
+      name: vault
+      endpoint: unix:///var/run/kmsplugin/socket.sock
+  - identity: {}
+`)},
+			},
+			expectedError: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		tc := tc
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := setEncryptedResources(&tc.secret, tc.resources)
+			if tc.expectedError {
+				if err == nil {
+					t.Fatalf("%s : expected error", tc.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s : failed to set encrypted resources %s", tc.name, err)
+			}
+
+			if diff := cmp.Diff(string(tc.expectedSecret.Data[EncryptionProviderConfig]), string(tc.secret.Data[EncryptionProviderConfig])); diff != "" {
+				t.Fatalf("%s : secrets are not equal %s", tc.name, diff)
+			}
+
+			changed, err := resourcesChanged(tc.secret, tc.resources)
+			if err != nil {
+				t.Fatalf("%s : failed to compare encrypted resources %s", tc.name, err)
+			}
+			if changed {
+				t.Fatalf("%s : expected encrypted resources to match %v", tc.name, tc.resources)
+			}
+		})
+	}
+}
+
+func Test_resolveResources(t *testing.T) {
+	apiResourceLists := []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "secrets", Kind: "Secret", Verbs: []string{"list", "get", "patch", "update"}},
+				{Name: "configmaps", Kind: "ConfigMap", Verbs: []string{"list", "get", "patch", "update"}},
+				{Name: "pods/status", Kind: "Pod", Verbs: []string{"get", "patch"}},
+				{Name: "bindings", Kind: "Binding", Verbs: []string{"create"}},
+			},
+		},
+		{
+			GroupVersion: "cert-manager.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "certificates", Kind: "Certificate", Verbs: []string{"list", "get", "patch", "update"}},
+				{Name: "issuers", Kind: "Issuer", Verbs: []string{"list", "get", "patch", "update"}},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Verbs: []string{"list", "get", "patch", "update"}},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name      string
+		resources []string
+		expected  []schema.GroupVersionKind
+	}{
+		{
+			name:      "case 0: secrets only",
+			resources: []string{"secrets"},
+			expected:  []schema.GroupVersionKind{{Version: "v1", Kind: "Secret"}},
+		},
+		{
+			name:      "case 1: resources of a group",
+			resources: []string{"secrets", "*.cert-manager.io"},
+			expected: []schema.GroupVersionKind{
+				{Version: "v1", Kind: "Secret"},
+				{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"},
+				{Group: "cert-manager.io", Version: "v1", Kind: "Issuer"},
+			},
+		},
+		{
+			name:      "case 2: all core resources which can be rewritten",
+			resources: []string{"*."},
+			expected: []schema.GroupVersionKind{
+				{Version: "v1", Kind: "Secret"},
+				{Version: "v1", Kind: "ConfigMap"},
+			},
+		},
+		{
+			name:      "case 3: all resources",
+			resources: []string{"*.*"},
+			expected: []schema.GroupVersionKind{
+				{Version: "v1", Kind: "Secret"},
+				{Version: "v1", Kind: "ConfigMap"},
+				{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"},
+				{Group: "cert-manager.io", Version: "v1", Kind: "Issuer"},
+				{Group: "apps", Version: "v1", Kind: "Deployment"},
+			},
+		},
+		{
+			name:      "case 4: resource with group",
+			resources: []string{"deployments.apps", "crontabs.stable.example.com"},
+			expected:  []schema.GroupVersionKind{{Group: "apps", Version: "v1", Kind: "Deployment"}},
+		},
+	}
+
+	for i, tc := range testCases {
+		tc := tc
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var kinds []schema.GroupVersionKind
+			for _, r := range resolveResources(apiResourceLists, tc.resources) {
+				kinds = append(kinds, r.Kind)
+			}
+
+			if !reflect.DeepEqual(kinds, tc.expected) {
+				t.Fatalf("%s : resolved resources are not equal %s", tc.name, cmp.Diff(tc.expected, kinds))
+			}
+		})
+	}
+}
+
+type preferredResourcesDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	resources []*metav1.APIResourceList
+	err       error
+}
+
+func (d *preferredResourcesDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	return d.resources, d.err
+}
+
+func Test_discoverResources(t *testing.T) {
+	discoveryClient := &preferredResourcesDiscovery{
+		resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "secrets", Kind: "Secret", Verbs: []string{"list", "patch"}},
+				},
+			},
+		},
+		err: &discovery.ErrGroupDiscoveryFailed{
+			Groups: map[schema.GroupVersion]error{
+				{Group: "cert-manager.io", Version: "v1"}: fmt.Errorf("service unavailable"),
+			},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		resources     []string
+		expectedError bool
+	}{
+		{
+			name:      "case 0: failed group is not encrypted",
+			resources: []string{"secrets", "deployments.apps"},
+		},
+		{
+			name:          "case 1: failed group has encrypted resource",
+			resources:     []string{"secrets", "certificates.cert-manager.io"},
+			expectedError: true,
+		},
+		{
+			name:          "case 2: failed group matches wildcard of the group",
+			resources:     []string{"*.cert-manager.io"},
+			expectedError: true,
+		},
+		{
+			name:          "case 3: failed group matches wildcard of all groups",
+			resources:     []string{"*.*"},
+			expectedError: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		tc := tc
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			resolved, err := discoverResources(discoveryClient, tc.resources)
+			if tc.expectedError {
+				if err == nil {
+					t.Fatalf("%s : expected error", tc.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s : unexpected error %s", tc.name, err)
+			}
+			if len(resolved) != 1 || resolved[0].Resource != "secrets" {
+				t.Fatalf("%s : expected secrets to be resolved but got %v", tc.name, resolved)
+			}
+		})
+	}
+}
+
+func Test_rewriteResources(t *testing.T) {
+	objects := []ctrlclient.Object{
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret-1", Namespace: "default"}},
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret-2", Namespace: "kube-system"}},
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default"}},
+	}
+	wcClient := fake.NewClientBuilder().WithObjects(objects...).Build()
+	discoveryClient := &preferredResourcesDiscovery{
+		resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "configmaps", Kind: "ConfigMap", Verbs: []string{"list", "patch"}},
+					{Name: "secrets", Kind: "Secret", Verbs: []string{"list", "patch"}},
+				},
+			},
+		},
+	}
+
+	s := &Service{
+		cluster: &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"}},
+		logger:  logr.Discard(),
+		rewrite: RewriteConfig{PageSize: DefaultRewritePageSize, Workers: 2},
+	}
+
+	// configmaps were rewritten before the rewrite was interrupted
+	checkpoint := &v1alpha1.RewriteCheckpoint{CompletedResources: []string{"configmaps"}, Rewritten: 1}
+	saved := 0
+	err := s.rewriteResources(context.Background(), wcClient, discoveryClient, []string{"secrets", "configmaps"}, checkpoint, func() error {
+		saved++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to rewrite resources %s", err)
+	}
+
+	if checkpoint.Rewritten != 3 {
+		t.Fatalf("expected 3 rewritten objects but got %d", checkpoint.Rewritten)
+	}
+	if !reflect.DeepEqual(checkpoint.CompletedResources, []string{"configmaps", "secrets"}) {
+		t.Fatalf("expected all resources to be completed but got %v", checkpoint.CompletedResources)
+	}
+	if checkpoint.Resource != "" || checkpoint.Continue != "" {
+		t.Fatalf("expected no resource in progress but got %q with continue token %q", checkpoint.Resource, checkpoint.Continue)
+	}
+	if saved == 0 {
+		t.Fatalf("expected checkpoint to be saved")
+	}
+
+	var secret v1.Secret
+	err = wcClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "secret-2", Namespace: "kube-system"}, &secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := secret.Annotations[annotation.EncryptionRewriteTimestamp]; !ok {
+		t.Fatalf("expected secret to be rewritten")
+	}
+
+	var configMap v1.ConfigMap
+	err = wcClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "config", Namespace: "default"}, &configMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := configMap.Annotations[annotation.EncryptionRewriteTimestamp]; ok {
+		t.Fatalf("expected completed configmaps not to be rewritten again")
+	}
+}
@@ -0,0 +1,288 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/giantswarm/k8smetadata/pkg/label"
+	"github.com/giantswarm/microerror"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	capiconditions "sigs.k8s.io/cluster-api/util/deprecated/v1beta1/conditions"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	configv1 "github.com/giantswarm/encryption-provider-operator/pkg/config"
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+	"github.com/giantswarm/encryption-provider-operator/pkg/project"
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
+)
+
+const (
+	// RestoreFromAnnotation on the Cluster restores a missing encryption provider config secret instead of generating a new key,
+	// the value is RestoreFromEscrow or the name of an archive secret of the cluster in the cluster namespace.
+	RestoreFromAnnotation = "encryption.giantswarm.io/restore-from"
+	// ForceNewKeyAnnotation on the Cluster allows generating a new key for an initialized cluster without encryption provider config secret,
+	// the data encrypted with the lost keys can not be read anymore.
+	ForceNewKeyAnnotation = "encryption.giantswarm.io/force-new-key"
+
+	// RestoreFromEscrow restores the keys from the key escrow.
+	RestoreFromEscrow = "escrow"
+)
+
+// RestoreConfig is the cluster and the source of the keys to restore its encryption provider config from.
+type RestoreConfig struct {
+	CtrlClient ctrlclient.Client
+	// KeyEscrow is required to restore from RestoreFromEscrow.
+	KeyEscrow KeyEscrow
+	Cluster   ctrlclient.ObjectKey
+	// From is RestoreFromEscrow or the name of an archive secret of the cluster.
+	From string
+	// Resources are encrypted if the keys are restored from the key escrow.
+	Resources []string
+}
+
+// RestoredKeys are the key material of a cluster read from a restore source.
+type RestoredKeys struct {
+	// Config is the encryption config of the cluster if the source has one, e.g. an archive.
+	Config []byte
+	// Keys are the escrowed, historic and legacy keys of the cluster.
+	Keys []HistoricKey
+	// History is the key history of the cluster if the source has one.
+	History []byte
+}
+
+// LoadRestoredKeys reads the key material of the cluster from the restore source
+func LoadRestoredKeys(ctx context.Context, c RestoreConfig) (*RestoredKeys, error) {
+	restored := &RestoredKeys{}
+
+	if c.From == RestoreFromEscrow {
+		if c.KeyEscrow == nil {
+			return nil, microerror.Mask(fmt.Errorf("cannot restore from %s without key escrow", RestoreFromEscrow))
+		}
+
+		escrowed, err := c.KeyEscrow.List(ctx, c.Cluster.Namespace, c.Cluster.Name)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		for _, k := range escrowed {
+			restored.Keys = appendHistoricKey(restored.Keys, HistoricKey{Name: k.Name, Provider: k.Provider, Secret: k.Secret, ActiveFrom: k.CreatedAt})
+		}
+
+		return restored, nil
+	}
+
+	var secret v1.Secret
+	err := c.CtrlClient.Get(ctx, ctrlclient.ObjectKey{Name: c.From, Namespace: c.Cluster.Namespace}, &secret)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if secret.Labels[capi.ClusterNameLabel] != c.Cluster.Name {
+		// never restore the keys of another cluster
+		return nil, microerror.Mask(fmt.Errorf("secret %s does not belong to cluster %s", c.From, c.Cluster.Name))
+	}
+	if len(secret.Data[EncryptionProviderConfig]) == 0 {
+		// a key history only has the retired keys, restoring from it would lose the current key
+		return nil, microerror.Mask(fmt.Errorf("secret %s has no encryption config, restore from an archive or %s", c.From, RestoreFromEscrow))
+	}
+
+	restored.Config = secret.Data[EncryptionProviderConfig]
+	restored.History = secret.Data[KeyHistory]
+
+	history, err := decodeKeyHistory(secret.Data[KeyHistory])
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	for _, k := range history {
+		restored.Keys = appendHistoricKey(restored.Keys, k)
+	}
+	if k, ok := secret.Data[LegacyEncryptionKey]; ok {
+		restored.Keys = appendHistoricKey(restored.Keys, HistoricKey{Name: keyName(1), Provider: providerAESCBC, Secret: string(k)})
+	}
+
+	return restored, nil
+}
+
+// EncryptionConfig returns the encryption config of the source, if the source only has keys the config is built from all keys,
+// the newest key encrypts new data and all other keys are kept to decrypt existing data
+func (r *RestoredKeys) EncryptionConfig(resources []string) ([]byte, error) {
+	if len(r.Config) > 0 {
+		return r.Config, nil
+	}
+	if len(r.Keys) == 0 {
+		return nil, microerror.Mask(fmt.Errorf("restore source does not contain any keys"))
+	}
+
+	keys := make([]HistoricKey, len(r.Keys))
+	copy(keys, r.Keys)
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].ActiveFrom.After(keys[j].ActiveFrom)
+	})
+
+	// group the keys by provider, the provider of the newest key is first, the key names must be unique per provider
+	var providers []configv1.ProviderConfiguration
+	index := map[string]int{}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if seen[k.Provider+"/"+k.Name] {
+			continue
+		}
+		seen[k.Provider+"/"+k.Name] = true
+
+		i, ok := index[k.Provider]
+		if !ok {
+			p, err := restoredProviderConfiguration(k.Provider)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+			i = len(providers)
+			index[k.Provider] = i
+			providers = append(providers, p)
+		}
+		providerKeys := providerKeys(providers[i])
+		*providerKeys = append(*providerKeys, configv1.Key{Name: k.Name, Secret: k.Secret})
+	}
+
+	ec := initNewEncryptionConfigStruct(providers[0], resources)
+	for i := range ec.Resources {
+		// keep identity as the last fallback
+		ec.Resources[i].Providers = append(append([]configv1.ProviderConfiguration{}, providers...), ec.Resources[i].Providers[1:]...)
+	}
+
+	o, err := yaml.Marshal(ec)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return o, nil
+}
+
+// restoredProviderConfiguration returns an empty configuration of the provider of a restored key
+func restoredProviderConfiguration(provider string) (configv1.ProviderConfiguration, error) {
+	switch provider {
+	case providerAESCBC:
+		return configv1.ProviderConfiguration{AESCBC: &configv1.AESConfiguration{}}, nil
+	case string(v1alpha1.ProviderAESGCM):
+		return configv1.ProviderConfiguration{AESGCM: &configv1.AESConfiguration{}}, nil
+	case string(v1alpha1.ProviderSecretbox):
+		return configv1.ProviderConfiguration{Secretbox: &configv1.SecretboxConfiguration{}}, nil
+	default:
+		return configv1.ProviderConfiguration{}, microerror.Mask(fmt.Errorf("cannot restore keys of encryption provider %q", provider))
+	}
+}
+
+// NewRestoredSecret builds the encryption provider config secret of the cluster from the restore source
+func NewRestoredSecret(ctx context.Context, c RestoreConfig) (*v1.Secret, *RestoredKeys, error) {
+	restored, err := LoadRestoredKeys(ctx, c)
+	if err != nil {
+		return nil, nil, microerror.Mask(err)
+	}
+
+	config, err := restored.EncryptionConfig(c.Resources)
+	if err != nil {
+		return nil, nil, microerror.Mask(err)
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.SecretName(c.Cluster.Name),
+			Namespace: c.Cluster.Namespace,
+			Labels: map[string]string{
+				label.Cluster:         c.Cluster.Name,
+				label.ManagedBy:       project.Name(),
+				capi.ClusterNameLabel: c.Cluster.Name,
+			},
+		},
+		Data: map[string][]byte{EncryptionProviderConfig: config},
+	}
+
+	return secret, restored, nil
+}
+
+// Restore creates the encryption provider config secret and the key history of the cluster from the restore source,
+// an existing encryption provider config secret is never overwritten
+func Restore(ctx context.Context, c RestoreConfig) (*v1.Secret, error) {
+	secret, restored, err := NewRestoredSecret(ctx, c)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	err = restoreKeyHistory(ctx, c.CtrlClient, c.Cluster, restored.History)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	err = c.CtrlClient.Create(ctx, secret)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return secret, nil
+}
+
+// restoreKeyHistory creates the key history secret from the history of the restore source, an existing history is kept
+func restoreKeyHistory(ctx context.Context, ctrlClient ctrlclient.Client, cluster ctrlclient.ObjectKey, history []byte) error {
+	if len(history) == 0 {
+		return nil
+	}
+
+	historySecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.KeyHistorySecretName(cluster.Name),
+			Namespace: cluster.Namespace,
+			Labels: map[string]string{
+				label.Cluster:         cluster.Name,
+				label.ManagedBy:       project.Name(),
+				capi.ClusterNameLabel: cluster.Name,
+			},
+		},
+		Data: map[string][]byte{KeyHistory: history},
+	}
+	err := ctrlClient.Create(ctx, historySecret)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// clusterInitialized returns true if the control plane of the cluster was initialized,
+// so etcd may already contain data encrypted with the keys of the cluster
+func clusterInitialized(cluster *capi.Cluster) bool {
+	return cluster.Status.ControlPlaneReady || capiconditions.IsTrue(cluster, capi.ControlPlaneInitializedCondition)
+}
+
+// restoreEncryptionProviderSecret restores the encryption provider config secret from the source in the restore annotation
+// and removes the annotation, so a later deletion of the secret does not restore stale keys
+func (s *Service) restoreEncryptionProviderSecret(ctx context.Context, from string) error {
+	secret, err := Restore(ctx, RestoreConfig{
+		CtrlClient: s.ctrlClient,
+		KeyEscrow:  s.keyEscrow,
+		Cluster:    ctrlclient.ObjectKeyFromObject(s.cluster),
+		From:       from,
+		Resources:  s.resources(),
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	s.logger.Info(fmt.Sprintf("restored encryption provider config secret from %s", from))
+	record.Eventf(s.cluster, "EncryptionConfigRestored", "Restored encryption provider config secret %s from %s", secret.Name, from)
+
+	err = s.reconcileOwnerReference(ctx, secret)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	base := s.cluster.DeepCopy()
+	delete(s.cluster.Annotations, RestoreFromAnnotation)
+	err = s.ctrlClient.Patch(ctx, s.cluster, ctrlclient.MergeFrom(base))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
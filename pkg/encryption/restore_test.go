@@ -0,0 +1,204 @@
+package encryption
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	capiconditions "sigs.k8s.io/cluster-api/util/deprecated/v1beta1/conditions"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/conditions"
+	configv1 "github.com/giantswarm/encryption-provider-operator/pkg/config"
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+)
+
+func testRestoreService(t *testing.T, cluster *capi.Cluster, objects ...ctrlclient.Object) *Service {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := capi.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Service{
+		cluster:                cluster,
+		ctrlClient:             fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objects, cluster)...).Build(),
+		defaultProvider:        v1alpha1.ProviderSecretbox,
+		defaultResources:       []string{DefaultEncryptedResource},
+		defaultRetentionPolicy: v1alpha1.RetentionPolicyDelete,
+		logger:                 logr.Discard(),
+	}
+}
+
+func testInitializedCluster(annotations map[string]string) *capi.Cluster {
+	return &capi.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test", Annotations: annotations},
+		Status:     capi.ClusterStatus{ControlPlaneReady: true},
+	}
+}
+
+func Test_Reconcile_restore(t *testing.T) {
+	ctx := context.Background()
+	archive := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.ArchiveSecretName("test", time.Unix(100, 0)),
+			Namespace: "org-test",
+			Labels:    map[string]string{capi.ClusterNameLabel: "test", ArchiveLabel: "true"},
+		},
+		Data: map[string][]byte{
+			EncryptionProviderConfig: testSecretboxConfig("key2").Data[EncryptionProviderConfig],
+			KeyHistory:               []byte(`[{"name":"key1","provider":"secretbox","secret":"secret-key1"}]`),
+		},
+	}
+	s := testRestoreService(t, testInitializedCluster(map[string]string{RestoreFromAnnotation: archive.Name}), archive)
+
+	err := s.Reconcile()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	var secret v1.Secret
+	err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{Name: key.SecretName("test"), Namespace: "org-test"}, &secret)
+	if err != nil {
+		t.Fatalf("expected restored encryption provider config secret but got %s", err)
+	}
+	if string(secret.Data[EncryptionProviderConfig]) != string(archive.Data[EncryptionProviderConfig]) {
+		t.Fatalf("expected archived encryption config to be restored but got %s", secret.Data[EncryptionProviderConfig])
+	}
+	_, history, err := s.getKeyHistory(ctx)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("expected key history to be restored but got %v %v", history, err)
+	}
+
+	var cluster capi.Cluster
+	err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKeyFromObject(s.cluster), &cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cluster.Annotations[RestoreFromAnnotation]; ok {
+		t.Fatalf("expected restore annotation to be removed")
+	}
+	if !capiconditions.IsTrue(s.cluster, conditions.EncryptionConfigReady) {
+		t.Fatalf("expected encryption config to be ready")
+	}
+}
+
+func Test_Reconcile_initializedClusterWithoutSecret(t *testing.T) {
+	ctx := context.Background()
+
+	// no new key is generated for an initialized cluster
+	s := testRestoreService(t, testInitializedCluster(nil))
+	err := s.Reconcile()
+	if !IsClusterInitialized(err) {
+		t.Fatalf("expected cluster initialized error but got %v", err)
+	}
+	err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{Name: key.SecretName("test"), Namespace: "org-test"}, &v1.Secret{})
+	if err == nil {
+		t.Fatalf("expected no encryption provider config secret to be generated")
+	}
+	if capiconditions.IsTrue(s.cluster, conditions.EncryptionConfigReady) {
+		t.Fatalf("expected encryption config not to be ready")
+	}
+
+	// a new key is generated if forced
+	s = testRestoreService(t, testInitializedCluster(map[string]string{ForceNewKeyAnnotation: "true"}))
+	err = s.Reconcile()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{Name: key.SecretName("test"), Namespace: "org-test"}, &v1.Secret{})
+	if err != nil {
+		t.Fatalf("expected encryption provider config secret to be generated but got %s", err)
+	}
+}
+
+func Test_Restore(t *testing.T) {
+	ctx := context.Background()
+	otherCluster := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.KeyHistorySecretName("other"),
+			Namespace: "org-test",
+			Labels:    map[string]string{capi.ClusterNameLabel: "other"},
+		},
+		Data: map[string][]byte{KeyHistory: []byte(`[{"name":"key1","provider":"secretbox","secret":"other"}]`)},
+	}
+	history := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.KeyHistorySecretName("test"),
+			Namespace: "org-test",
+			Labels:    map[string]string{capi.ClusterNameLabel: "test"},
+		},
+		Data: map[string][]byte{KeyHistory: []byte(`[{"name":"key1","provider":"secretbox","secret":"secret-key1"}]`)},
+	}
+	escrow := &testKeyEscrow{
+		escrowed: []EscrowedKey{
+			{Namespace: "org-test", Cluster: "test", Name: "key1", Provider: "secretbox", Secret: "secret-key1", CreatedAt: time.Unix(100, 0)},
+			{Namespace: "org-test", Cluster: "test", Name: "key3", Provider: "secretbox", Secret: "secret-key3", CreatedAt: time.Unix(300, 0)},
+			{Namespace: "org-test", Cluster: "test", Name: "key2", Provider: "secretbox", Secret: "secret-key2", CreatedAt: time.Unix(200, 0)},
+		},
+	}
+	c := RestoreConfig{
+		CtrlClient: fake.NewClientBuilder().WithObjects(otherCluster, history).Build(),
+		KeyEscrow:  escrow,
+		Cluster:    ctrlclient.ObjectKey{Name: "test", Namespace: "org-test"},
+		From:       otherCluster.Name,
+		Resources:  []string{"secrets", "configmaps"},
+	}
+
+	// the keys of another cluster are never restored
+	_, err := Restore(ctx, c)
+	if err == nil {
+		t.Fatalf("expected error when restoring keys of another cluster")
+	}
+
+	// a key history only has the retired keys and would lose the current key
+	c.From = history.Name
+	_, err = Restore(ctx, c)
+	if err == nil {
+		t.Fatalf("expected error when restoring from a key history")
+	}
+
+	// the config is built from the escrowed keys, the newest key encrypts new data
+	c.From = RestoreFromEscrow
+	secret, err := Restore(ctx, c)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	var ec configv1.EncryptionConfiguration
+	err = yaml.Unmarshal(secret.Data[EncryptionProviderConfig], &ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ec.Resources) != 1 || len(ec.Resources[0].Resources) != 2 {
+		t.Fatalf("expected restored config to encrypt the given resources but got %v", ec.Resources)
+	}
+	providers := ec.Resources[0].Providers
+	if len(providers) != 2 || providers[0].Secretbox == nil || providers[1].Identity == nil {
+		t.Fatalf("expected secretbox and identity provider but got %v", providers)
+	}
+	var names []string
+	for _, k := range providers[0].Secretbox.Keys {
+		names = append(names, k.Name)
+	}
+	if len(names) != 3 || names[0] != "key3" || names[1] != "key2" || names[2] != "key1" {
+		t.Fatalf("expected keys ordered from newest to oldest but got %v", names)
+	}
+
+	// an existing encryption provider config secret is never overwritten
+	_, err = Restore(ctx, c)
+	if err == nil {
+		t.Fatalf("expected error when encryption provider config secret exists")
+	}
+}
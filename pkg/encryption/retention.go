@@ -0,0 +1,137 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
+)
+
+// ValidateRetentionPolicy returns an error if the retention policy is not supported
+func ValidateRetentionPolicy(policy v1alpha1.RetentionPolicy) error {
+	switch policy {
+	case v1alpha1.RetentionPolicyDelete, v1alpha1.RetentionPolicyRetain, v1alpha1.RetentionPolicyArchive:
+		return nil
+	default:
+		return microerror.Mask(fmt.Errorf("unsupported retention policy %q", policy))
+	}
+}
+
+// retentionPolicy returns the retention policy from the EncryptionPolicy or the operator default
+func (s *Service) retentionPolicy() v1alpha1.RetentionPolicy {
+	if s.policy != nil && s.policy.Spec.RetentionPolicy != "" {
+		return s.policy.Spec.RetentionPolicy
+	}
+
+	return s.defaultRetentionPolicy
+}
+
+// ownerReferenceEnabled returns true if the encryption provider config secret is owned by the cluster,
+// the key material has to outlive the cluster with any other retention policy than Delete
+func (s *Service) ownerReferenceEnabled() bool {
+	return s.ownerReference && s.retentionPolicy() == v1alpha1.RetentionPolicyDelete
+}
+
+// setOwnerReference adds or removes the owner reference to the cluster on the secret, it returns true if the secret changed
+func (s *Service) setOwnerReference(secret *v1.Secret, owned bool) (bool, error) {
+	isOwned := false
+	for _, ref := range secret.OwnerReferences {
+		if ref.UID == s.cluster.UID {
+			isOwned = true
+		}
+	}
+
+	if owned == isOwned {
+		return false, nil
+	}
+
+	if owned {
+		err := controllerutil.SetOwnerReference(s.cluster, secret, s.ctrlClient.Scheme())
+		if err != nil {
+			return false, microerror.Mask(err)
+		}
+	} else {
+		err := controllerutil.RemoveOwnerReference(s.cluster, secret, s.ctrlClient.Scheme())
+		if err != nil {
+			return false, microerror.Mask(err)
+		}
+	}
+
+	return true, nil
+}
+
+// reconcileOwnerReference keeps the owner reference of the encryption provider config secret in line with the configuration
+func (s *Service) reconcileOwnerReference(ctx context.Context, secret *v1.Secret) error {
+	base := secret.DeepCopy()
+	changed, err := s.setOwnerReference(secret, s.ownerReferenceEnabled())
+	if err != nil {
+		return microerror.Mask(err)
+	} else if !changed {
+		return nil
+	}
+
+	err = s.ctrlClient.Patch(ctx, secret, ctrlclient.MergeFrom(base))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	s.logger.Info(fmt.Sprintf("updated owner reference of encryption provider config secret, owned by cluster: %t", s.ownerReferenceEnabled()))
+
+	return nil
+}
+
+// retainEncryptionProviderSecret applies the retention policy to the encryption provider config secret
+// and the key history secret of the deleted cluster
+func (s *Service) retainEncryptionProviderSecret(ctx context.Context) error {
+	var secrets []*v1.Secret
+	for _, name := range []string{key.SecretName(s.cluster.Name), key.KeyHistorySecretName(s.cluster.Name)} {
+		var secret v1.Secret
+		err := s.ctrlClient.Get(ctx, ctrlclient.ObjectKey{Name: name, Namespace: s.cluster.Namespace}, &secret)
+		if apierrors.IsNotFound(err) {
+			// secret is already deleted or was never created, nothing to retain
+			continue
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+		secrets = append(secrets, &secret)
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
+
+	switch s.retentionPolicy() {
+	case v1alpha1.RetentionPolicyRetain:
+		// the garbage collector must not delete the secrets together with the cluster
+		for _, secret := range secrets {
+			err := s.reconcileOwnerReference(ctx, secret)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+			s.logger.Info(fmt.Sprintf("retained secret %s with key material of deleted cluster", secret.Name))
+			record.Eventf(s.cluster, "EncryptionKeyRetained", "Retained secret %s with key material of the deleted cluster", secret.Name)
+		}
+
+	case v1alpha1.RetentionPolicyArchive:
+		err := s.archiveKeyMaterial(ctx, secrets)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+	default:
+		for _, secret := range secrets {
+			err := s.ctrlClient.Delete(ctx, secret)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return microerror.Mask(err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package encryption
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+)
+
+func Test_reconcileOwnerReference(t *testing.T) {
+	testCases := []struct {
+		name            string
+		ownerReference  bool
+		retentionPolicy v1alpha1.RetentionPolicy
+		owned           bool
+	}{
+		{
+			name:            "case 0: owner reference disabled",
+			retentionPolicy: v1alpha1.RetentionPolicyDelete,
+		},
+		{
+			name:            "case 1: owner reference enabled",
+			ownerReference:  true,
+			retentionPolicy: v1alpha1.RetentionPolicyDelete,
+			owned:           true,
+		},
+		{
+			name:            "case 2: owner reference enabled but key is retained",
+			ownerReference:  true,
+			retentionPolicy: v1alpha1.RetentionPolicyRetain,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, secret := testRetentionService(t, tc.ownerReference, tc.retentionPolicy)
+
+			err := s.reconcileOwnerReference(ctx, secret)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			var updated v1.Secret
+			err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKeyFromObject(secret), &updated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			owned := len(updated.OwnerReferences) == 1 && updated.OwnerReferences[0].UID == s.cluster.UID
+			if owned != tc.owned {
+				t.Fatalf("expected secret owned by cluster %t but got owner references %v", tc.owned, updated.OwnerReferences)
+			}
+		})
+	}
+}
+
+func Test_retainEncryptionProviderSecret(t *testing.T) {
+	testCases := []struct {
+		name            string
+		retentionPolicy v1alpha1.RetentionPolicy
+		secretExists    bool
+		archiveExists   bool
+	}{
+		{
+			name:            "case 0: delete",
+			retentionPolicy: v1alpha1.RetentionPolicyDelete,
+		},
+		{
+			name:            "case 1: retain",
+			retentionPolicy: v1alpha1.RetentionPolicyRetain,
+			secretExists:    true,
+		},
+		{
+			name:            "case 2: archive",
+			retentionPolicy: v1alpha1.RetentionPolicyArchive,
+			archiveExists:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, secret := testRetentionService(t, true, v1alpha1.RetentionPolicyDelete)
+			// the secret is owned by the cluster before the retention policy changed
+			err := s.reconcileOwnerReference(ctx, secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.defaultRetentionPolicy = tc.retentionPolicy
+
+			err = s.retainEncryptionProviderSecret(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			var retained v1.Secret
+			err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKeyFromObject(secret), &retained)
+			if tc.secretExists {
+				if err != nil {
+					t.Fatalf("expected secret to be retained but got %s", err)
+				}
+				if len(retained.OwnerReferences) != 0 {
+					t.Fatalf("expected owner reference of retained secret to be removed")
+				}
+			} else if !apierrors.IsNotFound(err) {
+				t.Fatalf("expected secret to be deleted but got %v", err)
+			}
+
+			var archives v1.SecretList
+			err = s.ctrlClient.List(ctx, &archives, ctrlclient.MatchingLabels{ArchiveLabel: "true"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.archiveExists {
+				if len(archives.Items) != 1 {
+					t.Fatalf("expected 1 archive secret but got %d", len(archives.Items))
+				}
+				if string(archives.Items[0].Data[EncryptionProviderConfig]) != "config" {
+					t.Fatalf("expected archived key material but got %q", archives.Items[0].Data[EncryptionProviderConfig])
+				}
+			} else if len(archives.Items) != 0 {
+				t.Fatalf("expected no archive secret but got %d", len(archives.Items))
+			}
+		})
+	}
+}
+
+func testRetentionService(t *testing.T, ownerReference bool, retentionPolicy v1alpha1.RetentionPolicy) (*Service, *v1.Secret) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := capi.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test", UID: "cluster-uid"}}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: key.SecretName("test"), Namespace: "org-test"},
+		Data:       map[string][]byte{EncryptionProviderConfig: []byte("config")},
+	}
+
+	return &Service{
+		cluster:                cluster,
+		ctrlClient:             fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster, secret).Build(),
+		logger:                 logr.Discard(),
+		ownerReference:         ownerReference,
+		defaultRetentionPolicy: retentionPolicy,
+	}, secret
+}
@@ -0,0 +1,536 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/util/flowcontrol"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/metrics"
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
+)
+
+// getRotationStatus reads the rotation status stored on the encryption provider secret
+func getRotationStatus(secret v1.Secret) (*v1alpha1.RotationStatus, error) {
+	status := &v1alpha1.RotationStatus{}
+
+	if v, ok := secret.Annotations[v1alpha1.RotationStatusAnnotation]; ok {
+		err := json.Unmarshal([]byte(v), status)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		return status, nil
+	}
+
+	if _, ok := secret.Annotations[annotation.EncryptionRotationInProgress]; ok {
+		// rotation was started by an operator version without phases,
+		// the new key is already added and the hasher app deployed
+		status.Phase = v1alpha1.RotationPhaseWaitingForControlPlane
+	}
+
+	return status, nil
+}
+
+// startRotation adds a new encryption key to the config and persists it together with the KeyAdded phase
+func (s *Service) startRotation(ctx context.Context, secret *v1.Secret, status *v1alpha1.RotationStatus) error {
+	// generate new encryption key, for kms the KMS provider is added instead
+	oldSecret := secret.DeepCopy()
+	err := s.addNewProvider(ctx, secret)
+	if err != nil {
+		s.logger.Error(err, "failed to add new encryption key to the configuration secret")
+		return microerror.Mask(err)
+	}
+	// the added key is recorded, so the rotation can be cancelled
+	newKeyProvider, newKeyName, err := addedKey(*oldSecret, *secret)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	resourcesChanged, err := resourcesChanged(*secret, s.resources())
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	err = setEncryptedResources(secret, s.resources())
+	if err != nil {
+		s.logger.Error(err, "failed to set encrypted resources in the configuration secret")
+		return microerror.Mask(err)
+	}
+
+	now := metav1.Now()
+	*status = v1alpha1.RotationStatus{StartedAt: &now, NewKeyProvider: newKeyProvider, NewKeyName: newKeyName, ResourcesChanged: resourcesChanged}
+	status.SetPhase(v1alpha1.RotationPhaseKeyAdded, now)
+
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[annotation.EncryptionRotationInProgress] = "true"
+	// delete the Force rotation annotation if it exists
+	delete(secret.Annotations, annotation.EncryptionForceRotation)
+
+	err = s.updateRotationStatus(ctx, secret, status)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	s.logger.Info("added new encryption key to the config, key rotation started")
+	record.Eventf(s.cluster, "EncryptionKeyRotationStarted", "Added new %s encryption provider key to encryption provider config secret %s", s.provider(), secret.Name)
+
+	return nil
+}
+
+// continueRotation runs the phases of an in-progress rotation with the client of the workload cluster
+func (s *Service) continueRotation(ctx context.Context, secret *v1.Secret, status *v1alpha1.RotationStatus, clusterName string) error {
+	// get workload cluster k8s client, the rotation is not failed as the workload cluster may only be unreachable for a moment
+	wcClient, err := s.clusterCache.GetClient(ctx, ctrlclient.ObjectKey{Name: clusterName, Namespace: s.cluster.Namespace})
+	if err != nil {
+		s.logger.Error(err, fmt.Sprintf("failed to get workload cluster client, retrying key rotation phase %s", status.Phase))
+		return microerror.Mask(err)
+	}
+
+	return s.runRotationPhases(ctx, wcClient, secret, status)
+}
+
+// runRotationPhases runs the phases of an in-progress rotation until it has to wait for the workload cluster,
+// every phase transition is persisted before the next phase starts
+func (s *Service) runRotationPhases(ctx context.Context, wcClient ctrlclient.Client, secret *v1.Secret, status *v1alpha1.RotationStatus) error {
+	if status.Phase == v1alpha1.RotationPhaseFailed {
+		phase := status.FailedPhase
+		if phase == "" {
+			phase = v1alpha1.RotationPhaseKeyAdded
+		}
+		s.logger.Info(fmt.Sprintf("retrying failed key rotation phase %s", phase))
+		// keep the time the phase was entered first, it is the rolloutAfter of the control plane,
+		// a new time would roll out the control plane again on every retry
+		enteredAt := metav1.Now()
+		if t := status.PhaseTime(phase); t != nil {
+			enteredAt = *t
+		}
+		status.SetPhase(phase, enteredAt)
+	}
+
+	for status.Phase.InProgress() {
+		var next v1alpha1.RotationPhase
+		var err error
+
+		switch status.Phase {
+		case v1alpha1.RotationPhaseKeyAdded:
+			next, err = s.rotationKeyAdded(ctx, wcClient, secret, status)
+		case v1alpha1.RotationPhaseWaitingForControlPlane:
+			next, err = s.rotationWaitingForControlPlane(ctx, wcClient, secret, status)
+		case v1alpha1.RotationPhaseRewritingSecrets:
+			next, err = s.rotationRewritingSecrets(ctx, secret, status)
+		case v1alpha1.RotationPhasePruningOldKey:
+			next, err = s.rotationPruningOldKey(ctx, wcClient, secret, status)
+		case v1alpha1.RotationPhaseRollingOutControlPlane:
+			next, err = s.rotationRollingOutControlPlane(ctx, secret, status)
+		case v1alpha1.RotationPhaseRevertingKey:
+			next, err = s.rotationRevertingKey(ctx, wcClient, secret, status)
+		default:
+			err = fmt.Errorf("unknown key rotation phase %q", status.Phase)
+		}
+		if err != nil {
+			s.failRotation(ctx, secret, status, err)
+			return microerror.Mask(err)
+		}
+
+		if next == status.Phase {
+			// phase is waiting for the workload cluster, check again in next reconciliation loop
+			return nil
+		}
+
+		s.logger.Info(fmt.Sprintf("key rotation moved from phase %s to %s", status.Phase, next))
+		previous := status.Phase
+		status.SetPhase(next, metav1.Now())
+
+		err = s.updateRotationStatus(ctx, secret, status)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		if previous == v1alpha1.RotationPhasePruningOldKey {
+			record.Eventf(s.cluster, "OldEncryptionKeyPruned", "Removed old encryption key from encryption provider config secret %s", secret.Name)
+		}
+		if next == v1alpha1.RotationPhaseCompleted {
+			record.Eventf(s.cluster, "EncryptionKeyRotationCompleted", "Key rotation of encryption provider config secret %s completed", secret.Name)
+		}
+		if next == v1alpha1.RotationPhaseCancelled {
+			record.Eventf(s.cluster, "EncryptionKeyRotationCancelled", "Key rotation of encryption provider config secret %s cancelled, all control plane nodes use the config without the new key", secret.Name)
+		}
+	}
+
+	return nil
+}
+
+// rotationKeyAdded verifies the new key is escrowed, deploys the app that reports the hash of the encryption config
+// on the control plane nodes and rolls out the control plane if enabled, so the nodes pick up the config with the new key
+func (s *Service) rotationKeyAdded(ctx context.Context, wcClient ctrlclient.Client, secret *v1.Secret, status *v1alpha1.RotationStatus) (v1alpha1.RotationPhase, error) {
+	err := s.verifyCurrentKeyEscrow(ctx, *secret)
+	if err != nil {
+		s.logger.Error(err, "failed to verify escrow of the new encryption key")
+		return v1alpha1.RotationPhaseKeyAdded, microerror.Mask(err)
+	}
+
+	err = s.deployEncryptionProviderHasherApp(ctx, wcClient)
+	if err != nil {
+		s.logger.Error(err, "failed to deploy encryption-config-hasher app to workload cluster")
+		return v1alpha1.RotationPhaseKeyAdded, microerror.Mask(err)
+	}
+
+	if s.controlPlaneRollout {
+		_, err = s.rolloutControlPlane(ctx, status, v1alpha1.RotationPhaseKeyAdded)
+		if err != nil {
+			return v1alpha1.RotationPhaseKeyAdded, microerror.Mask(err)
+		}
+	}
+
+	return v1alpha1.RotationPhaseWaitingForControlPlane, nil
+}
+
+// rotationWaitingForControlPlane waits until all control plane nodes use the config with the new key,
+// the number of expected nodes is taken from the control plane referenced by the cluster
+func (s *Service) rotationWaitingForControlPlane(ctx context.Context, wcClient ctrlclient.Client, secret *v1.Secret, status *v1alpha1.RotationStatus) (v1alpha1.RotationPhase, error) {
+	/*
+		short description of what we do here
+		- the workload cluster should run app that run pod on each master node
+		and check the encryption config file on host  and save its md5 sum to the secret called EncryptionProviderConfigShake256SecretName
+		this secret will then have md5 check sum of the file for each master
+		- we fetch the secret and compare the values of md5 checksum with expected value
+		- this has to match for each master node
+		- in case all master nodes has same new config file we can rewrite all secrets in the workload cluster
+	*/
+	converged, err := s.waitForControlPlane(ctx, wcClient, secret, status)
+	if err != nil {
+		return v1alpha1.RotationPhaseWaitingForControlPlane, microerror.Mask(err)
+	} else if !converged {
+		return v1alpha1.RotationPhaseWaitingForControlPlane, nil
+	}
+
+	return v1alpha1.RotationPhaseRewritingSecrets, nil
+}
+
+// waitForControlPlane returns true once all control plane nodes use the config of the secret,
+// while waiting the reason is reported in the rotation status and the hasher app is kept up to date
+func (s *Service) waitForControlPlane(ctx context.Context, wcClient ctrlclient.Client, secret *v1.Secret, status *v1alpha1.RotationStatus) (bool, error) {
+	// calculate checksum of the encryption provider config file
+	configShakeSum := shake256Sum(secret.Data[EncryptionProviderConfig])
+	masterNodesUpToDate, reason, err := s.areAllMasterNodesUsingLatestConfig(ctx, wcClient, configShakeSum)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	if !masterNodesUpToDate {
+		// report why the rotation is waiting
+		if status.Message != reason {
+			status.Message = reason
+			err = s.updateRotationStatus(ctx, secret, status)
+			if err != nil {
+				return false, microerror.Mask(err)
+			}
+		}
+
+		// update the chart app in case there has been a change
+		err = s.deployEncryptionProviderHasherApp(ctx, wcClient)
+		if err != nil {
+			return false, microerror.Mask(err)
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// rotationRewritingSecrets rewrites all objects of the resources in the config in workload cluster so new keys is used for encryption,
+// resources removed from the config are rewritten unencrypted, the progress is persisted after every page
+func (s *Service) rotationRewritingSecrets(ctx context.Context, secret *v1.Secret, status *v1alpha1.RotationStatus) (v1alpha1.RotationPhase, error) {
+	deferred, err := s.deferRotation(time.Now())
+	if err != nil {
+		return v1alpha1.RotationPhaseRewritingSecrets, microerror.Mask(err)
+	} else if deferred {
+		// the rewrite loads the workload cluster, it only runs in the maintenance window
+		if status.Message != s.deferred {
+			status.Message = s.deferred
+			err = s.updateRotationStatus(ctx, secret, status)
+			if err != nil {
+				return v1alpha1.RotationPhaseRewritingSecrets, microerror.Mask(err)
+			}
+		}
+		return v1alpha1.RotationPhaseRewritingSecrets, nil
+	}
+
+	resources, err := configResources(*secret)
+	if err != nil {
+		return v1alpha1.RotationPhaseRewritingSecrets, microerror.Mask(err)
+	}
+
+	// use a dedicated rate limited client, so the rewrite does not overload the workload cluster API
+	restConfig, err := s.clusterCache.GetRestConfig(ctx, ctrlclient.ObjectKeyFromObject(s.cluster))
+	if err != nil {
+		return v1alpha1.RotationPhaseRewritingSecrets, microerror.Mask(err)
+	}
+	if s.rewrite.QPS > 0 {
+		restConfig.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(s.rewrite.QPS, s.rewrite.Burst)
+	}
+	rewriteClient, err := ctrlclient.New(restConfig, ctrlclient.Options{})
+	if err != nil {
+		return v1alpha1.RotationPhaseRewritingSecrets, microerror.Mask(err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return v1alpha1.RotationPhaseRewritingSecrets, microerror.Mask(err)
+	}
+
+	if s.storageVersionMigration {
+		available, err := storageVersionMigrationAvailable(discoveryClient)
+		if err != nil {
+			return v1alpha1.RotationPhaseRewritingSecrets, microerror.Mask(err)
+		}
+		if available {
+			return s.rotationMigratingResources(ctx, rewriteClient, discoveryClient, resources, secret, status)
+		}
+		s.logger.Info("storage version migration API is not available in the workload cluster, rewriting resources with annotation")
+	}
+
+	if status.RewriteCheckpoint == nil {
+		status.RewriteCheckpoint = &v1alpha1.RewriteCheckpoint{}
+	} else {
+		s.logger.Info(fmt.Sprintf("resuming rewrite of resources after %d rewritten objects", status.RewriteCheckpoint.Rewritten))
+	}
+
+	err = s.rewriteResources(ctx, rewriteClient, discoveryClient, resources, status.RewriteCheckpoint, func() error {
+		return s.updateRotationStatus(ctx, secret, status)
+	})
+	if err != nil {
+		s.logger.Error(err, "failed to rewrite all encrypted resources in workload cluster cluster")
+		return v1alpha1.RotationPhaseRewritingSecrets, microerror.Mask(err)
+	}
+	s.logger.Info(fmt.Sprintf("all objects of resources %v on the workload cluster has been rewritten with the new encryption key", resources))
+	record.Eventf(s.cluster, "ResourcesRewritten", "Rewrote %d objects of resources %s in the workload cluster with the new encryption key", status.RewriteCheckpoint.Rewritten, strings.Join(resources, ", "))
+
+	return v1alpha1.RotationPhasePruningOldKey, nil
+}
+
+// rotationMigratingResources re-encrypts the resources with StorageVersionMigrations, the phase waits until all migrations succeeded
+func (s *Service) rotationMigratingResources(ctx context.Context, wcClient ctrlclient.Client, discoveryClient discovery.DiscoveryInterface, resources []string, secret *v1.Secret, status *v1alpha1.RotationStatus) (v1alpha1.RotationPhase, error) {
+	done, err := s.migrateResources(ctx, wcClient, discoveryClient, resources, status)
+	if err != nil {
+		s.logger.Error(err, "failed to migrate encrypted resources in workload cluster")
+		return v1alpha1.RotationPhaseRewritingSecrets, microerror.Mask(err)
+	}
+
+	if !done {
+		// persist the migrated resources, the phase is checked again in next reconciliation loop
+		err = s.updateRotationStatus(ctx, secret, status)
+		if err != nil {
+			return v1alpha1.RotationPhaseRewritingSecrets, microerror.Mask(err)
+		}
+		return v1alpha1.RotationPhaseRewritingSecrets, nil
+	}
+
+	s.logger.Info(fmt.Sprintf("all resources %v on the workload cluster has been migrated with the new encryption key", resources))
+	record.Eventf(s.cluster, "ResourcesMigrated", "Migrated resources %s in the workload cluster with storage version migrations to the new encryption key", strings.Join(resources, ", "))
+
+	return v1alpha1.RotationPhasePruningOldKey, nil
+}
+
+// rotationPruningOldKey removes the hasher app and the old key, the old key is escrowed to the key history
+// before the config change is persisted together with the Completed phase
+func (s *Service) rotationPruningOldKey(ctx context.Context, wcClient ctrlclient.Client, secret *v1.Secret, status *v1alpha1.RotationStatus) (v1alpha1.RotationPhase, error) {
+	// delete the app that watches the encryption config
+	err := s.deleteEncryptionProviderHasherApp(ctx, wcClient)
+	if err != nil {
+		s.logger.Error(err, "failed to delete encryption-config-hasher app from workload cluster")
+		return v1alpha1.RotationPhasePruningOldKey, microerror.Mask(err)
+	}
+	s.logger.Info("removed encryption-config-hasher app from workload cluster")
+	record.Event(s.cluster, "EncryptionConfigHasherDeleted", "Removed encryption-config-hasher app from the workload cluster")
+
+	oldSecret := secret.DeepCopy()
+	err = removeOldEncryptionKey(secret)
+	if err != nil {
+		s.logger.Error(err, "failed to remove old encryption key from the configuration secret")
+		return v1alpha1.RotationPhasePruningOldKey, microerror.Mask(err)
+	}
+
+	err = s.escrowRetiredKeys(ctx, *oldSecret, *secret, status)
+	if err != nil {
+		s.logger.Error(err, "failed to escrow old encryption key to the key history")
+		return v1alpha1.RotationPhasePruningOldKey, microerror.Mask(err)
+	}
+	s.logger.Info("removed old key from the encryption config")
+
+	if s.controlPlaneRollout {
+		// the config without the old key is persisted before the control plane is rolled out
+		return v1alpha1.RotationPhaseRollingOutControlPlane, nil
+	}
+
+	completeRotation(secret)
+
+	return v1alpha1.RotationPhaseCompleted, nil
+}
+
+// rotationRollingOutControlPlane rolls out the control plane, so the nodes pick up the config without the old key,
+// the phase waits until the rollout finished
+func (s *Service) rotationRollingOutControlPlane(ctx context.Context, secret *v1.Secret, status *v1alpha1.RotationStatus) (v1alpha1.RotationPhase, error) {
+	triggered, err := s.rolloutControlPlane(ctx, status, v1alpha1.RotationPhaseRollingOutControlPlane)
+	if err != nil {
+		return v1alpha1.RotationPhaseRollingOutControlPlane, microerror.Mask(err)
+	} else if triggered {
+		// the control plane provider has not seen the rollout yet, check again in next reconciliation loop
+		return v1alpha1.RotationPhaseRollingOutControlPlane, nil
+	}
+
+	rolledOut, reason, err := s.controlPlaneRolledOut(ctx)
+	if err != nil {
+		return v1alpha1.RotationPhaseRollingOutControlPlane, microerror.Mask(err)
+	}
+
+	if !rolledOut {
+		s.logger.Info(reason)
+		if status.Message != reason {
+			status.Message = reason
+			err = s.updateRotationStatus(ctx, secret, status)
+			if err != nil {
+				return v1alpha1.RotationPhaseRollingOutControlPlane, microerror.Mask(err)
+			}
+		}
+		return v1alpha1.RotationPhaseRollingOutControlPlane, nil
+	}
+
+	s.logger.Info("control plane rolled out the encryption config without the old key")
+	completeRotation(secret)
+
+	return v1alpha1.RotationPhaseCompleted, nil
+}
+
+// rolloutControlPlane replaces all control plane machines created before the given phase was entered,
+// it returns true if the rollout was triggered by this call
+func (s *Service) rolloutControlPlane(ctx context.Context, status *v1alpha1.RotationStatus, phase v1alpha1.RotationPhase) (bool, error) {
+	rolloutAfter := status.PhaseTime(phase)
+	if rolloutAfter == nil {
+		now := metav1.Now()
+		rolloutAfter = &now
+	}
+
+	triggered, err := s.triggerControlPlaneRollout(ctx, *rolloutAfter)
+	if err != nil {
+		s.logger.Error(err, "failed to roll out the control plane")
+		return false, microerror.Mask(err)
+	}
+	if triggered {
+		s.logger.Info(fmt.Sprintf("set rolloutAfter %s on the control plane", rolloutAfter.UTC().Format(time.RFC3339)))
+		record.Eventf(s.cluster, "ControlPlaneRolloutTriggered", "Rolling out control plane machines created before %s to apply the encryption provider config", rolloutAfter.UTC().Format(time.RFC3339))
+	}
+
+	return triggered, nil
+}
+
+// completeRotation marks the rotation as finished on the encryption provider secret
+func completeRotation(secret *v1.Secret) {
+	secret.Annotations[annotation.EncryptionLastRotation] = time.Now().Format(time.RFC3339)
+	delete(secret.Annotations, annotation.EncryptionRotationInProgress)
+}
+
+// failRotation marks the rotation as failed in the given phase, only the status annotation is patched
+// so partial changes to the config in memory are never persisted
+func (s *Service) failRotation(ctx context.Context, secret *v1.Secret, status *v1alpha1.RotationStatus, cause error) {
+	failedPhase := status.Phase
+	status.SetPhase(v1alpha1.RotationPhaseFailed, metav1.Now())
+	status.FailedPhase = failedPhase
+	status.Message = cause.Error()
+
+	s.logger.Error(cause, fmt.Sprintf("key rotation failed in phase %s", failedPhase))
+	metrics.IncRotationFailures(s.cluster.Namespace, s.cluster.Name, failedPhase)
+	record.Warnf(s.cluster, "EncryptionKeyRotationFailed", "Key rotation failed in phase %s: %s", failedPhase, cause)
+
+	encodedStatus, err := json.Marshal(status)
+	if err != nil {
+		s.logger.Error(err, "failed to encode rotation status")
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				v1alpha1.RotationStatusAnnotation: string(encodedStatus),
+			},
+		},
+	})
+	if err != nil {
+		s.logger.Error(err, "failed to encode rotation status patch")
+		return
+	}
+
+	failedSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secret.Name,
+			Namespace: secret.Namespace,
+		},
+	}
+	err = s.ctrlClient.Patch(ctx, failedSecret, ctrlclient.RawPatch(types.MergePatchType, patch))
+	if err != nil {
+		s.logger.Error(err, "failed to persist failed rotation phase on encryption provider secret")
+		return
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[v1alpha1.RotationStatusAnnotation] = string(encodedStatus)
+
+	err = s.reportRotationStatus(ctx, status)
+	if err != nil {
+		s.logger.Error(err, "failed to report rotation status on encryption policy")
+	}
+}
+
+// updateRotationStatus stores the rotation status on the secret and updates it together with its data
+func (s *Service) updateRotationStatus(ctx context.Context, secret *v1.Secret, status *v1alpha1.RotationStatus) error {
+	encodedStatus, err := json.Marshal(status)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[v1alpha1.RotationStatusAnnotation] = string(encodedStatus)
+
+	err = s.ctrlClient.Update(ctx, secret)
+	if err != nil {
+		s.logger.Error(err, "failed to update encryption provider secret")
+		return microerror.Mask(err)
+	}
+
+	err = s.reportRotationStatus(ctx, status)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// reportRotationStatus mirrors the rotation status to the EncryptionPolicy status if the cluster has one
+func (s *Service) reportRotationStatus(ctx context.Context, status *v1alpha1.RotationStatus) error {
+	if s.policy == nil || equality.Semantic.DeepEqual(s.policy.Status.Rotation, status) {
+		return nil
+	}
+
+	patch := ctrlclient.MergeFrom(s.policy.DeepCopy())
+	s.policy.Status.Rotation = status.DeepCopy()
+
+	err := s.ctrlClient.Status().Patch(ctx, s.policy, patch)
+	if err != nil {
+		s.logger.Error(err, "failed to update rotation status of encryption policy")
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
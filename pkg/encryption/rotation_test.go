@@ -0,0 +1,491 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	chartv1 "github.com/giantswarm/apiextensions-application/api/v1alpha1"
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clientrecord "k8s.io/client-go/tools/record"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+	"github.com/giantswarm/encryption-provider-operator/pkg/record"
+	"github.com/giantswarm/encryption-provider-operator/pkg/remote"
+)
+
+var testRecorder = newTestRecorder()
+
+func newTestRecorder() *clientrecord.FakeRecorder {
+	r := clientrecord.NewFakeRecorder(100)
+	record.InitFromRecorder(r)
+	return r
+}
+
+// recordedEvents drains the events recorded since the last call and returns their type and reason
+func recordedEvents() []string {
+	var events []string
+	for {
+		select {
+		case e := <-testRecorder.Events:
+			fields := strings.SplitN(e, " ", 3)
+			events = append(events, strings.Join(fields[:2], " "))
+		default:
+			return events
+		}
+	}
+}
+
+func Test_getRotationStatus(t *testing.T) {
+	testCases := []struct {
+		name          string
+		secret        v1.Secret
+		expectedPhase v1alpha1.RotationPhase
+	}{
+		{
+			name:          "case 0: no rotation started yet",
+			secret:        v1.Secret{},
+			expectedPhase: "",
+		},
+		{
+			name: "case 1: rotation started by operator without phases",
+			secret: v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						annotation.EncryptionRotationInProgress: "true",
+					},
+				},
+			},
+			expectedPhase: v1alpha1.RotationPhaseWaitingForControlPlane,
+		},
+		{
+			name: "case 2: rotation status stored on the secret",
+			secret: v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						annotation.EncryptionRotationInProgress: "true",
+						v1alpha1.RotationStatusAnnotation:       `{"phase":"RewritingSecrets","phaseTransitions":[{"phase":"RewritingSecrets","time":"2026-01-02T03:04:05Z"}]}`,
+					},
+				},
+			},
+			expectedPhase: v1alpha1.RotationPhaseRewritingSecrets,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			status, err := getRotationStatus(tc.secret)
+			if err != nil {
+				t.Fatalf("%s : failed to get rotation status %s", tc.name, err)
+			}
+
+			if status.Phase != tc.expectedPhase {
+				t.Fatalf("%s : expected phase %q but got %q", tc.name, tc.expectedPhase, status.Phase)
+			}
+		})
+	}
+}
+
+func Test_RotationStatus_SetPhase(t *testing.T) {
+	status := v1alpha1.RotationStatus{}
+	first := metav1.Unix(100, 0)
+	second := metav1.Unix(200, 0)
+
+	status.SetPhase(v1alpha1.RotationPhaseRewritingSecrets, first)
+	status.SetPhase(v1alpha1.RotationPhaseFailed, first)
+	status.FailedPhase = v1alpha1.RotationPhaseRewritingSecrets
+	status.SetPhase(v1alpha1.RotationPhaseRewritingSecrets, second)
+
+	if status.FailedPhase != "" {
+		t.Fatalf("expected failed phase to be cleared but got %q", status.FailedPhase)
+	}
+	if len(status.PhaseTransitions) != 2 {
+		t.Fatalf("expected 2 phase transitions but got %d", len(status.PhaseTransitions))
+	}
+	if !status.PhaseTransitions[0].Time.Equal(&second) {
+		t.Fatalf("expected transition time of phase %s to be updated", v1alpha1.RotationPhaseRewritingSecrets)
+	}
+}
+
+func testRotationSecret(t *testing.T, status v1alpha1.RotationStatus, keys ...string) *v1.Secret {
+	secret := testSecretboxConfig(keys...)
+	secret.ObjectMeta = metav1.ObjectMeta{
+		Name:      key.SecretName("test"),
+		Namespace: "org-test",
+		Annotations: map[string]string{
+			annotation.EncryptionRotationInProgress: "true",
+		},
+	}
+	if status.Phase != "" {
+		encodedStatus, err := json.Marshal(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret.Annotations[v1alpha1.RotationStatusAnnotation] = string(encodedStatus)
+	}
+
+	return &secret
+}
+
+func testRotationService(t *testing.T, secret *v1.Secret) *Service {
+	// the window opens every year on the 1st of january for a minute, it is closed at nearly any time
+	w, err := ParseMaintenanceWindow("0 0 1 1 *", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrlClient := fake.NewClientBuilder().WithObjects(secret).Build()
+	clusterCache, err := remote.NewClusterCache(remote.Config{CtrlClient: ctrlClient})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Service{
+		cluster:                  &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"}},
+		clusterCache:             clusterCache,
+		ctrlClient:               ctrlClient,
+		defaultMaintenanceWindow: w,
+		defaultProvider:          v1alpha1.ProviderSecretbox,
+		defaultResources:         []string{DefaultEncryptedResource},
+		logger:                   logr.Discard(),
+	}
+}
+
+// persistedRotation returns the rotation status and key names of the encryption provider config secret in the management cluster
+func persistedRotation(t *testing.T, s *Service, secret *v1.Secret) (*v1.Secret, *v1alpha1.RotationStatus, []string) {
+	var persisted v1.Secret
+	err := s.ctrlClient.Get(context.Background(), ctrlclient.ObjectKeyFromObject(secret), &persisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := getRotationStatus(persisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := configKeys(persisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, k := range keys {
+		names = append(names, k.Name)
+	}
+
+	return &persisted, status, names
+}
+
+func Test_keyRotation(t *testing.T) {
+	testCases := []struct {
+		name          string
+		secret        func(t *testing.T) *v1.Secret
+		expectedPhase v1alpha1.RotationPhase
+		expectedKeys  []string
+	}{
+		{
+			name: "case 0: forced rotation adds the key before the workload cluster is reached",
+			secret: func(t *testing.T) *v1.Secret {
+				secret := testRotationSecret(t, v1alpha1.RotationStatus{}, "key1")
+				secret.Annotations = map[string]string{annotation.EncryptionForceRotation: "true", annotation.EncryptionEnableRotation: "true"}
+				return secret
+			},
+			expectedPhase: v1alpha1.RotationPhaseKeyAdded,
+			expectedKeys:  []string{"key2", "key1"},
+		},
+		{
+			name: "case 1: unreachable workload cluster keeps the phase",
+			secret: func(t *testing.T) *v1.Secret {
+				return testRotationSecret(t, v1alpha1.RotationStatus{Phase: v1alpha1.RotationPhaseWaitingForControlPlane}, "key2", "key1")
+			},
+			expectedPhase: v1alpha1.RotationPhaseWaitingForControlPlane,
+			expectedKeys:  []string{"key2", "key1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			secret := tc.secret(t)
+			s := testRotationService(t, secret)
+			s.defaultMaintenanceWindow = nil
+
+			// the kubeconfig of the workload cluster does not exist, so the workload cluster client can not be created
+			err := s.keyRotation(context.Background(), secret.DeepCopy(), "test")
+			if err == nil {
+				t.Fatalf("expected error when workload cluster client can not be created")
+			}
+
+			persisted, status, keys := persistedRotation(t, s, secret)
+			if status.Phase != tc.expectedPhase {
+				t.Fatalf("expected phase %s but got %s with message %q", tc.expectedPhase, status.Phase, status.Message)
+			}
+			if !reflect.DeepEqual(keys, tc.expectedKeys) {
+				t.Fatalf("expected keys %v but got %v", tc.expectedKeys, keys)
+			}
+			if _, ok := persisted.Annotations[annotation.EncryptionRotationInProgress]; !ok {
+				t.Fatalf("expected rotation to be in progress")
+			}
+			if _, ok := persisted.Annotations[annotation.EncryptionForceRotation]; ok {
+				t.Fatalf("expected force rotation annotation to be removed")
+			}
+		})
+	}
+}
+
+func Test_startRotation(t *testing.T) {
+	ctx := context.Background()
+	secret := testRotationSecret(t, v1alpha1.RotationStatus{}, "key1")
+	secret.Annotations = map[string]string{annotation.EncryptionForceRotation: "true"}
+	s := testRotationService(t, secret)
+
+	status := &v1alpha1.RotationStatus{}
+	recordedEvents()
+	err := s.startRotation(ctx, secret, status)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	persisted, persistedStatus, keys := persistedRotation(t, s, secret)
+	if persistedStatus.Phase != v1alpha1.RotationPhaseKeyAdded || persistedStatus.StartedAt == nil {
+		t.Fatalf("expected started rotation in phase %s but got %+v", v1alpha1.RotationPhaseKeyAdded, persistedStatus)
+	}
+	if persistedStatus.NewKeyProvider != string(v1alpha1.ProviderSecretbox) || persistedStatus.NewKeyName != "key2" {
+		t.Fatalf("expected new secretbox key key2 to be recorded but got %s %s", persistedStatus.NewKeyProvider, persistedStatus.NewKeyName)
+	}
+	if !reflect.DeepEqual(keys, []string{"key2", "key1"}) {
+		t.Fatalf("expected new key to encrypt and old key to decrypt but got %v", keys)
+	}
+	if _, ok := persisted.Annotations[annotation.EncryptionRotationInProgress]; !ok {
+		t.Fatalf("expected rotation to be in progress")
+	}
+	if _, ok := persisted.Annotations[annotation.EncryptionForceRotation]; ok {
+		t.Fatalf("expected force rotation annotation to be removed")
+	}
+	if events := recordedEvents(); !reflect.DeepEqual(events, []string{"Normal EncryptionKeyRotationStarted"}) {
+		t.Fatalf("expected rotation started event but got %v", events)
+	}
+}
+
+func Test_runRotationPhases(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := chartv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name               string
+		status             v1alpha1.RotationStatus
+		keys               []string
+		nodesUpToDate      bool
+		hasherDeployed     bool
+		hasherOutdated     bool
+		expectedPhase      v1alpha1.RotationPhase
+		expectedKeys       []string
+		expectedHasher     bool
+		expectedInProgress bool
+		expectedEvents     []string
+	}{
+		{
+			name:               "case 0: key added, hasher is deployed",
+			status:             v1alpha1.RotationStatus{Phase: v1alpha1.RotationPhaseKeyAdded},
+			keys:               []string{"key2", "key1"},
+			expectedPhase:      v1alpha1.RotationPhaseWaitingForControlPlane,
+			expectedKeys:       []string{"key2", "key1"},
+			expectedHasher:     true,
+			expectedInProgress: true,
+			expectedEvents:     []string{"Normal EncryptionConfigHasherDeployed"},
+		},
+		{
+			name:               "case 1: control plane nodes use the old config",
+			status:             v1alpha1.RotationStatus{Phase: v1alpha1.RotationPhaseWaitingForControlPlane},
+			keys:               []string{"key2", "key1"},
+			hasherDeployed:     true,
+			expectedPhase:      v1alpha1.RotationPhaseWaitingForControlPlane,
+			expectedKeys:       []string{"key2", "key1"},
+			expectedHasher:     true,
+			expectedInProgress: true,
+		},
+		{
+			name:               "case 2: control plane nodes use the new config, rewrite waits for the maintenance window",
+			status:             v1alpha1.RotationStatus{Phase: v1alpha1.RotationPhaseWaitingForControlPlane},
+			keys:               []string{"key2", "key1"},
+			nodesUpToDate:      true,
+			hasherDeployed:     true,
+			expectedPhase:      v1alpha1.RotationPhaseRewritingSecrets,
+			expectedKeys:       []string{"key2", "key1"},
+			expectedHasher:     true,
+			expectedInProgress: true,
+			expectedEvents:     []string{"Normal ControlPlaneConverged"},
+		},
+		{
+			name:           "case 3: old key is pruned",
+			status:         v1alpha1.RotationStatus{Phase: v1alpha1.RotationPhasePruningOldKey},
+			keys:           []string{"key2", "key1"},
+			nodesUpToDate:  true,
+			hasherDeployed: true,
+			expectedPhase:  v1alpha1.RotationPhaseCompleted,
+			expectedKeys:   []string{"key2"},
+			expectedEvents: []string{"Normal EncryptionConfigHasherDeleted", "Normal EncryptionKeyEscrowed", "Normal OldEncryptionKeyPruned", "Normal EncryptionKeyRotationCompleted"},
+		},
+		{
+			name:           "case 4: failed phase is retried",
+			status:         v1alpha1.RotationStatus{Phase: v1alpha1.RotationPhaseFailed, FailedPhase: v1alpha1.RotationPhasePruningOldKey},
+			keys:           []string{"key2", "key1"},
+			hasherDeployed: true,
+			expectedPhase:  v1alpha1.RotationPhaseCompleted,
+			expectedKeys:   []string{"key2"},
+			expectedEvents: []string{"Normal EncryptionConfigHasherDeleted", "Normal EncryptionKeyEscrowed", "Normal OldEncryptionKeyPruned", "Normal EncryptionKeyRotationCompleted"},
+		},
+		{
+			name:           "case 5: new key is reverted",
+			status:         v1alpha1.RotationStatus{Phase: v1alpha1.RotationPhaseRevertingKey},
+			keys:           []string{"key1"},
+			nodesUpToDate:  true,
+			hasherDeployed: true,
+			expectedPhase:  v1alpha1.RotationPhaseCancelled,
+			expectedKeys:   []string{"key1"},
+			expectedEvents: []string{"Normal ControlPlaneConverged", "Normal EncryptionKeyRotationCancelled"},
+		},
+		{
+			name:               "case 6: outdated hasher app is updated",
+			status:             v1alpha1.RotationStatus{Phase: v1alpha1.RotationPhaseWaitingForControlPlane},
+			keys:               []string{"key2", "key1"},
+			hasherDeployed:     true,
+			hasherOutdated:     true,
+			expectedPhase:      v1alpha1.RotationPhaseWaitingForControlPlane,
+			expectedKeys:       []string{"key2", "key1"},
+			expectedHasher:     true,
+			expectedInProgress: true,
+			expectedEvents:     []string{"Normal EncryptionConfigHasherUpdated"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			secret := testRotationSecret(t, tc.status, tc.keys...)
+			s := testRotationService(t, secret)
+
+			shakeSecret := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      EncryptionProviderConfigShake256SecretName,
+					Namespace: EncryptionProviderConfigShake256SecretNamespace,
+				},
+				Data: map[string][]byte{},
+			}
+			objects := []ctrlclient.Object{shakeSecret}
+			for i := 0; i < 3; i++ {
+				sum := "outdated"
+				if tc.nodesUpToDate {
+					sum = shake256Sum(secret.Data[EncryptionProviderConfig])
+				}
+				node := testControlPlaneNode(fmt.Sprintf("master-%d", i), true)
+				shakeSecret.Data[node.Name] = []byte(sum)
+				objects = append(objects, node)
+			}
+			if tc.hasherDeployed {
+				chart := buildAppChart(s.appCatalog)
+				if tc.hasherOutdated {
+					chart.Spec.Version = "0.0.1"
+				}
+				objects = append(objects, chart, buildConfigMapValues(s.registryDomain))
+			}
+			wcClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+			status, err := getRotationStatus(*secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recordedEvents()
+			err = s.runRotationPhases(ctx, wcClient, secret, status)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			persisted, persistedStatus, keys := persistedRotation(t, s, secret)
+			if persistedStatus.Phase != tc.expectedPhase {
+				t.Fatalf("expected phase %s but got %s with message %q", tc.expectedPhase, persistedStatus.Phase, persistedStatus.Message)
+			}
+			if !reflect.DeepEqual(keys, tc.expectedKeys) {
+				t.Fatalf("expected keys %v but got %v", tc.expectedKeys, keys)
+			}
+			if _, ok := persisted.Annotations[annotation.EncryptionRotationInProgress]; ok != tc.expectedInProgress {
+				t.Fatalf("expected rotation in progress %t but got %t", tc.expectedInProgress, ok)
+			}
+
+			err = wcClient.Get(ctx, ctrlclient.ObjectKeyFromObject(buildAppChart(s.appCatalog)), &chartv1.Chart{})
+			if apierrors.IsNotFound(err) == tc.expectedHasher {
+				t.Fatalf("expected hasher deployed %t but got %v", tc.expectedHasher, err)
+			}
+			if events := recordedEvents(); !reflect.DeepEqual(events, tc.expectedEvents) {
+				t.Fatalf("expected events %v but got %v", tc.expectedEvents, events)
+			}
+		})
+	}
+}
+
+func Test_runRotationPhases_retryControlPlaneRollout(t *testing.T) {
+	ctx := context.Background()
+	rolloutAfter := metav1.Unix(1700000000, 0)
+	secret := testRotationSecret(t, v1alpha1.RotationStatus{
+		Phase:       v1alpha1.RotationPhaseFailed,
+		FailedPhase: v1alpha1.RotationPhaseRollingOutControlPlane,
+		PhaseTransitions: []v1alpha1.PhaseTransition{
+			{Phase: v1alpha1.RotationPhaseRollingOutControlPlane, Time: rolloutAfter},
+			{Phase: v1alpha1.RotationPhaseFailed, Time: metav1.Unix(1700000600, 0)},
+		},
+	}, "key2")
+	s := testRotationService(t, secret)
+	s.controlPlaneRollout = true
+
+	// the rollout was triggered before the phase failed
+	kcp := testKubeadmControlPlane(3, 3, 3)
+	err := unstructured.SetNestedField(kcp.Object, rolloutAfter.UTC().Format(time.RFC3339), "spec", "rolloutAfter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.ctrlClient.Create(ctx, kcp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resourceVersion := kcp.GetResourceVersion()
+	s.cluster.Spec.ControlPlaneRef = &v1.ObjectReference{APIVersion: kcp.GetAPIVersion(), Kind: kcp.GetKind(), Name: kcp.GetName()}
+
+	status, err := getRotationStatus(*secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.runRotationPhases(ctx, fake.NewClientBuilder().Build(), secret, status)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	_, persistedStatus, _ := persistedRotation(t, s, secret)
+	if persistedStatus.Phase != v1alpha1.RotationPhaseCompleted {
+		t.Fatalf("expected phase %s but got %s with message %q", v1alpha1.RotationPhaseCompleted, persistedStatus.Phase, persistedStatus.Message)
+	}
+
+	err = s.ctrlClient.Get(ctx, ctrlclient.ObjectKeyFromObject(kcp), kcp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kcp.GetResourceVersion() != resourceVersion {
+		t.Fatalf("expected control plane not to be patched again on retry")
+	}
+}
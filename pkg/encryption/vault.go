@@ -0,0 +1,274 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	// DefaultVaultMount is the mount path of the KV v2 secrets engine keys are escrowed to.
+	DefaultVaultMount = "secret"
+	// DefaultVaultPathPrefix is the path in the KV v2 secrets engine below which the keys of all clusters are escrowed.
+	DefaultVaultPathPrefix = "encryption-provider-operator"
+)
+
+// VaultConfig configures the key escrow in a HashiCorp Vault KV v2 secrets engine.
+type VaultConfig struct {
+	// Address is the URL of the Vault server, e.g. https://vault.example.com:8200.
+	Address string
+	// Token is a static Vault token, it is only used if TokenFile is empty.
+	Token string
+	// TokenFile is the path of a file with the Vault token, e.g. a mounted secret. It is read for every request,
+	// so a renewed or replaced token is used without a restart.
+	TokenFile string
+	// Mount is the mount path of the KV v2 secrets engine, defaults to DefaultVaultMount.
+	Mount string
+	// PathPrefix is the path below which the keys are escrowed, defaults to DefaultVaultPathPrefix.
+	PathPrefix string
+	// HTTPClient is used for requests to Vault, defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+}
+
+// VaultKeyEscrow escrows keys to a HashiCorp Vault KV v2 secrets engine,
+// every key is written to <mount>/data/<prefix>/<namespace>/<cluster>/<provider>/<name>-<fingerprint>.
+type VaultKeyEscrow struct {
+	address    string
+	token      string
+	tokenFile  string
+	mount      string
+	pathPrefix string
+	httpClient *http.Client
+}
+
+type vaultKey struct {
+	Namespace string    `json:"namespace"`
+	Cluster   string    `json:"cluster"`
+	Name      string    `json:"name"`
+	Provider  string    `json:"provider"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func NewVaultKeyEscrow(c VaultConfig) (*VaultKeyEscrow, error) {
+	if c.Address == "" {
+		return nil, microerror.Mask(fmt.Errorf("vault address cannot be empty"))
+	}
+	if c.Token == "" && c.TokenFile == "" {
+		return nil, microerror.Mask(fmt.Errorf("vault token and token file cannot both be empty"))
+	}
+	if _, err := url.Parse(c.Address); err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if c.Mount == "" {
+		c.Mount = DefaultVaultMount
+	}
+	if c.PathPrefix == "" {
+		c.PathPrefix = DefaultVaultPathPrefix
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	v := &VaultKeyEscrow{
+		address:    strings.TrimSuffix(c.Address, "/"),
+		token:      c.Token,
+		tokenFile:  c.TokenFile,
+		mount:      strings.Trim(c.Mount, "/"),
+		pathPrefix: strings.Trim(c.PathPrefix, "/"),
+		httpClient: c.HTTPClient,
+	}
+
+	// fail early if the token file is missing
+	if _, err := v.currentToken(); err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return v, nil
+}
+
+// Store writes the key as a new version of its KV v2 secret
+func (v *VaultKeyEscrow) Store(ctx context.Context, k EscrowedKey) error {
+	body, err := json.Marshal(map[string]vaultKey{"data": vaultKey(k)})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	resp, err := v.request(ctx, http.MethodPost, fmt.Sprintf("%s/v1/%s/data/%s", v.address, v.mount, v.secretPath(k)), body)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return microerror.Mask(vaultError(resp, "failed to escrow %s encryption key %s", k.Provider, k.Name))
+	}
+
+	return nil
+}
+
+// Verify reads the latest version of the KV v2 secret of the key and compares the escrowed key
+func (v *VaultKeyEscrow) Verify(ctx context.Context, k EscrowedKey) error {
+	escrowed, err := v.read(ctx, v.secretPath(k))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if escrowed.Secret != k.Secret {
+		return microerror.Mask(fmt.Errorf("escrowed %s encryption key %s differs from the key in the config", k.Provider, k.Name))
+	}
+
+	return nil
+}
+
+// List reads all keys escrowed below the path of the cluster
+func (v *VaultKeyEscrow) List(ctx context.Context, namespace string, cluster string) ([]EscrowedKey, error) {
+	clusterPath := path.Join(v.pathPrefix, namespace, cluster)
+	providers, err := v.list(ctx, clusterPath)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var keys []EscrowedKey
+	for _, provider := range providers {
+		names, err := v.list(ctx, path.Join(clusterPath, provider))
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		for _, name := range names {
+			k, err := v.read(ctx, path.Join(clusterPath, provider, name))
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+			keys = append(keys, EscrowedKey(k))
+		}
+	}
+
+	return keys, nil
+}
+
+// list returns the names below the path of the KV v2 secrets engine, nothing if the path does not exist
+func (v *VaultKeyEscrow) list(ctx context.Context, p string) ([]string, error) {
+	resp, err := v.request(ctx, http.MethodGet, fmt.Sprintf("%s/v1/%s/metadata/%s?list=true", v.address, v.mount, p), nil)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, microerror.Mask(vaultError(resp, "failed to list escrowed keys in %s", p))
+	}
+
+	var list struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	names := make([]string, 0, len(list.Data.Keys))
+	for _, k := range list.Data.Keys {
+		names = append(names, strings.TrimSuffix(k, "/"))
+	}
+
+	return names, nil
+}
+
+// read returns the latest version of the escrowed key at the path
+func (v *VaultKeyEscrow) read(ctx context.Context, p string) (vaultKey, error) {
+	resp, err := v.request(ctx, http.MethodGet, fmt.Sprintf("%s/v1/%s/data/%s", v.address, v.mount, p), nil)
+	if err != nil {
+		return vaultKey{}, microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return vaultKey{}, microerror.Mask(vaultError(resp, "failed to read escrowed key %s", p))
+	}
+
+	var secret struct {
+		Data struct {
+			Data vaultKey `json:"data"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&secret)
+	if err != nil {
+		return vaultKey{}, microerror.Mask(err)
+	}
+
+	return secret.Data.Data, nil
+}
+
+// secretPath returns the path of the key in the KV v2 secrets engine, the fingerprint keeps keys
+// with the same name of a recreated cluster apart
+func (v *VaultKeyEscrow) secretPath(k EscrowedKey) string {
+	fingerprint := sha256.Sum256([]byte(k.Secret))
+	return path.Join(v.pathPrefix, k.Namespace, k.Cluster, k.Provider, fmt.Sprintf("%s-%x", k.Name, fingerprint[:4]))
+}
+
+// currentToken returns the token of the token file or the static token if no file is configured
+func (v *VaultKeyEscrow) currentToken() (string, error) {
+	if v.tokenFile == "" {
+		return v.token, nil
+	}
+
+	b, err := os.ReadFile(v.tokenFile)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", microerror.Mask(fmt.Errorf("vault token file %s is empty", v.tokenFile))
+	}
+
+	return token, nil
+}
+
+func (v *VaultKeyEscrow) request(ctx context.Context, method string, u string, body []byte) (*http.Response, error) {
+	token, err := v.currentToken()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return resp, nil
+}
+
+// vaultError returns an error with the status and the errors returned by Vault
+func vaultError(resp *http.Response, format string, args ...interface{}) error {
+	var body struct {
+		Errors []string `json:"errors"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	_ = json.Unmarshal(b, &body)
+
+	return fmt.Errorf("%s: vault returned %s %s", fmt.Sprintf(format, args...), resp.Status, strings.Join(body.Errors, ", "))
+}
@@ -0,0 +1,190 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testVaultServer is a stand-in for the KV v2 secrets engine mounted at secret/
+type testVaultServer struct {
+	mu      sync.Mutex
+	secrets map[string]json.RawMessage
+}
+
+func (v *testVaultServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "token" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if p, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/metadata/"); ok && r.URL.Query().Get("list") == "true" {
+		// list the next path segment of all secrets below the path
+		seen := map[string]bool{}
+		keys := []string{}
+		for secretPath := range v.secrets {
+			rest, ok := strings.CutPrefix(secretPath, p+"/")
+			if !ok {
+				continue
+			}
+			name, _, dir := strings.Cut(rest, "/")
+			if dir {
+				name += "/"
+			}
+			if !seen[name] {
+				seen[name] = true
+				keys = append(keys, name)
+			}
+		}
+		if len(keys) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+		return
+	}
+
+	p, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/data/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		var body struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		v.secrets[p] = body.Data
+		_, _ = w.Write([]byte(`{"data":{"version":1}}`))
+	case http.MethodGet:
+		data, ok := v.secrets[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data}})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func Test_VaultKeyEscrow(t *testing.T) {
+	ctx := context.Background()
+	vault := &testVaultServer{secrets: map[string]json.RawMessage{}}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	escrow, err := NewVaultKeyEscrow(VaultConfig{Address: server.URL, Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := EscrowedKey{Namespace: "org-test", Cluster: "test", Name: "key2", Provider: "secretbox", Secret: "c2VjcmV0"}
+
+	// a key which was never stored is not escrowed
+	err = escrow.Verify(ctx, k)
+	if err == nil {
+		t.Fatalf("expected verify of missing key to fail")
+	}
+
+	err = escrow.Store(ctx, k)
+	if err != nil {
+		t.Fatalf("failed to store key %s", err)
+	}
+	err = escrow.Verify(ctx, k)
+	if err != nil {
+		t.Fatalf("failed to verify key %s", err)
+	}
+	for p := range vault.secrets {
+		if !strings.HasPrefix(p, DefaultVaultPathPrefix+"/org-test/test/secretbox/key2-") || strings.Count(p, "/") != 4 {
+			t.Fatalf("unexpected path of escrowed key %s", p)
+		}
+	}
+
+	// all escrowed keys of the cluster are listed
+	other := EscrowedKey{Namespace: "org-test", Cluster: "test", Name: "key1", Provider: "aescbc", Secret: "b2xk"}
+	err = escrow.Store(ctx, other)
+	if err != nil {
+		t.Fatalf("failed to store key %s", err)
+	}
+	keys, err := escrow.List(ctx, "org-test", "test")
+	if err != nil {
+		t.Fatalf("failed to list keys %s", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 escrowed keys but got %v", keys)
+	}
+	keys, err = escrow.List(ctx, "org-test", "unknown")
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("expected no escrowed keys of unknown cluster but got %v %v", keys, err)
+	}
+
+	// a key with the same name but a different secret is a different key
+	k.Secret = "b3RoZXI="
+	err = escrow.Verify(ctx, k)
+	if err == nil {
+		t.Fatalf("expected verify of different key to fail")
+	}
+
+	// errors returned by vault are reported
+	denied, err := NewVaultKeyEscrow(VaultConfig{Address: server.URL, Token: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = denied.Store(ctx, k)
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("expected permission denied error but got %v", err)
+	}
+}
+
+func Test_VaultKeyEscrow_tokenFile(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(&testVaultServer{secrets: map[string]json.RawMessage{}})
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	_, err := NewVaultKeyEscrow(VaultConfig{Address: server.URL, TokenFile: tokenFile})
+	if err == nil {
+		t.Fatalf("expected missing token file to fail")
+	}
+
+	err = os.WriteFile(tokenFile, []byte("expired\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	escrow, err := NewVaultKeyEscrow(VaultConfig{Address: server.URL, Token: "ignored", TokenFile: tokenFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := EscrowedKey{Namespace: "org-test", Cluster: "test", Name: "key2", Provider: "secretbox", Secret: "c2VjcmV0"}
+	err = escrow.Store(ctx, k)
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("expected permission denied error but got %v", err)
+	}
+
+	// the renewed token is used without recreating the escrow
+	err = os.WriteFile(tokenFile, []byte("token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = escrow.Store(ctx, k)
+	if err != nil {
+		t.Fatalf("failed to store key with renewed token %s", err)
+	}
+}
@@ -0,0 +1,130 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/robfig/cron/v3"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+)
+
+// MaintenanceWindow is a recurring time window in which new key rotations start and resources are rewritten.
+type MaintenanceWindow struct {
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+// ParseMaintenanceWindow parses the cron schedule of the window starts, the window is nil if the schedule is empty,
+// so rotations are not restricted
+func ParseMaintenanceWindow(schedule string, duration time.Duration) (*MaintenanceWindow, error) {
+	if schedule == "" {
+		return nil, nil
+	}
+
+	s, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, microerror.Mask(fmt.Errorf("invalid maintenance window schedule %q: %s", schedule, err))
+	}
+	if duration <= 0 {
+		return nil, microerror.Mask(fmt.Errorf("maintenance window duration must be positive, got %s", duration))
+	}
+
+	return &MaintenanceWindow{schedule: s, duration: duration}, nil
+}
+
+// ValidateMaintenanceWindow returns an error if the maintenance window of an EncryptionPolicy can not be parsed
+func ValidateMaintenanceWindow(w *v1alpha1.MaintenanceWindow) error {
+	if w == nil {
+		return nil
+	}
+
+	_, err := ParseMaintenanceWindow(w.Schedule, w.Duration.Duration)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// Open returns true if the window is open at now, if it is closed the time it opens next is returned as well,
+// a nil window is always open
+func (w *MaintenanceWindow) Open(now time.Time) (bool, time.Time) {
+	if w == nil {
+		return true, time.Time{}
+	}
+
+	// the first start after now minus the duration is either the start of the open window or the next start
+	start := w.schedule.Next(now.UTC().Add(-w.duration))
+	if !start.After(now) {
+		return true, time.Time{}
+	}
+
+	return false, start
+}
+
+// maintenanceWindow returns the maintenance window of the cluster, the EncryptionPolicy takes precedence over the operator default
+func (s *Service) maintenanceWindow() (*MaintenanceWindow, error) {
+	if s.policy != nil && s.policy.Spec.Rotation.MaintenanceWindow != nil {
+		w := s.policy.Spec.Rotation.MaintenanceWindow
+		return ParseMaintenanceWindow(w.Schedule, w.Duration.Duration)
+	}
+
+	return s.defaultMaintenanceWindow, nil
+}
+
+// deferRotation returns true if the maintenance window of the cluster is closed,
+// the time the window opens next is reported in the conditions of the cluster
+func (s *Service) deferRotation(now time.Time) (bool, error) {
+	w, err := s.maintenanceWindow()
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	open, next := w.Open(now)
+	if open {
+		return false, nil
+	}
+
+	s.deferred = fmt.Sprintf("deferred until %s", next.UTC().Format(time.RFC3339))
+	s.logger.Info(fmt.Sprintf("maintenance window is closed, key rotation %s", s.deferred))
+
+	return true, nil
+}
+
+// deferNewRotation returns true if a new rotation has to wait for the maintenance window, the previous rotation waves
+// or for other clusters of the fleet to finish their rotation, otherwise a rotation slot of the fleet is reserved for the cluster.
+// The previous waves have to complete a rotation after the cycle start, zero only waits for their started rotations.
+func (s *Service) deferNewRotation(ctx context.Context, cycleStart time.Time) (bool, error) {
+	now := time.Now()
+	// the waves are checked first, so the due rotation blocks later waves also while the maintenance window is closed
+	ready, reason, err := s.fleet.WaveReady(ctx, s.cluster, cycleStart, now)
+	if err != nil {
+		return false, microerror.Mask(err)
+	} else if !ready {
+		s.deferred = fmt.Sprintf("deferred, %s", reason)
+		s.logger.Info(fmt.Sprintf("previous rotation wave is not completed, key rotation %s", s.deferred))
+		return true, nil
+	}
+
+	deferred, err := s.deferRotation(now)
+	if err != nil {
+		return false, microerror.Mask(err)
+	} else if deferred {
+		return true, nil
+	}
+
+	acquired, inProgress, err := s.fleet.Acquire(ctx, ctrlclient.ObjectKeyFromObject(s.cluster), now)
+	if err != nil {
+		return false, microerror.Mask(err)
+	} else if !acquired {
+		s.deferred = fmt.Sprintf("deferred, %d key rotations are in progress in the fleet", inProgress)
+		s.logger.Info(fmt.Sprintf("maximal number of concurrent rotations reached, key rotation %s", s.deferred))
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,156 @@
+package encryption
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+)
+
+func Test_ParseMaintenanceWindow(t *testing.T) {
+	testCases := []struct {
+		name         string
+		schedule     string
+		duration     time.Duration
+		expectNil    bool
+		errorMatcher string
+	}{
+		{
+			name:      "case 0: no schedule",
+			expectNil: true,
+		},
+		{
+			name:     "case 1: valid schedule",
+			schedule: "0 2 * * SAT",
+			duration: time.Hour,
+		},
+		{
+			name:     "case 2: schedule with time zone",
+			schedule: "CRON_TZ=Europe/Berlin 0 2 * * *",
+			duration: time.Hour,
+		},
+		{
+			name:         "case 3: invalid schedule",
+			schedule:     "every night",
+			duration:     time.Hour,
+			errorMatcher: "invalid maintenance window schedule",
+		},
+		{
+			name:         "case 4: no duration",
+			schedule:     "0 2 * * *",
+			errorMatcher: "duration must be positive",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := ParseMaintenanceWindow(tc.schedule, tc.duration)
+			if tc.errorMatcher != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errorMatcher) {
+					t.Fatalf("expected error matching %q but got %v", tc.errorMatcher, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if (w == nil) != tc.expectNil {
+				t.Fatalf("expected nil window %t but got %v", tc.expectNil, w)
+			}
+		})
+	}
+}
+
+func Test_MaintenanceWindow_Open(t *testing.T) {
+	// saturday 02:00 UTC for 4 hours
+	w, err := ParseMaintenanceWindow("0 2 * * SAT", 4*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name         string
+		window       *MaintenanceWindow
+		now          time.Time
+		expectedOpen bool
+		expectedNext time.Time
+	}{
+		{
+			name:         "case 0: no window",
+			now:          time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+			expectedOpen: true,
+		},
+		{
+			name:         "case 1: before the window",
+			window:       w,
+			now:          time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+			expectedNext: time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:         "case 2: window start",
+			window:       w,
+			now:          time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC),
+			expectedOpen: true,
+		},
+		{
+			name:         "case 3: inside the window in another time zone",
+			window:       w,
+			now:          time.Date(2026, 10, 17, 5, 59, 0, 0, time.UTC).In(time.FixedZone("UTC-8", -8*3600)),
+			expectedOpen: true,
+		},
+		{
+			name:         "case 4: window closed",
+			window:       w,
+			now:          time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC),
+			expectedNext: time.Date(2026, 10, 24, 2, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			open, next := tc.window.Open(tc.now)
+			if open != tc.expectedOpen {
+				t.Fatalf("expected open %t but got %t", tc.expectedOpen, open)
+			}
+			if !next.Equal(tc.expectedNext) {
+				t.Fatalf("expected window to open at %s but got %s", tc.expectedNext, next)
+			}
+		})
+	}
+}
+
+func Test_rotationRewritingSecrets_deferred(t *testing.T) {
+	ctx := context.Background()
+	secret := testSecretboxConfig("key2", "key1")
+	secret.ObjectMeta = metav1.ObjectMeta{Name: "test-encryption-provider-config", Namespace: "org-test"}
+
+	// the window opens every year on the 1st of january for a minute, it is closed at nearly any time
+	w, err := ParseMaintenanceWindow("0 0 1 1 *", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{
+		cluster:                  &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "org-test"}},
+		ctrlClient:               fake.NewClientBuilder().WithObjects(&secret).Build(),
+		defaultMaintenanceWindow: w,
+		logger:                   logr.Discard(),
+	}
+	status := &v1alpha1.RotationStatus{Phase: v1alpha1.RotationPhaseRewritingSecrets}
+
+	next, err := s.rotationRewritingSecrets(ctx, &secret, status)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if next != v1alpha1.RotationPhaseRewritingSecrets {
+		t.Fatalf("expected rewrite to wait for the maintenance window but moved to %s", next)
+	}
+	if !strings.HasPrefix(status.Message, "deferred until ") {
+		t.Fatalf("expected deferred message but got %q", status.Message)
+	}
+}
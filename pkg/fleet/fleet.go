@@ -0,0 +1,306 @@
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	"github.com/giantswarm/microerror"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+	"github.com/giantswarm/encryption-provider-operator/pkg/label"
+	"github.com/giantswarm/encryption-provider-operator/pkg/project"
+)
+
+const (
+	// DefaultReservationTimeout is the time a started rotation is counted before it shows up in the cache of the client.
+	DefaultReservationTimeout = time.Minute
+	// DefaultDueTimeout is the time a cluster with a due rotation blocks later waves after it was last reconciled,
+	// it is longer than the requeue interval of the clusters.
+	DefaultDueTimeout = 15 * time.Minute
+)
+
+type Config struct {
+	// CtrlClient is the management cluster client used to list the encryption provider config secrets, usually backed by the cache.
+	CtrlClient client.Client
+	// MaxConcurrentRotations is the maximal number of clusters rotating their key at the same time, not limited if zero.
+	MaxConcurrentRotations int
+	// Jitter is the fraction of the rotation period by which the rotation of a cluster starts earlier, between 0 and 1.
+	Jitter float64
+	// ReservationTimeout defaults to DefaultReservationTimeout.
+	ReservationTimeout time.Duration
+	// Waves are label selectors of the clusters rotating together, a wave only starts rotations once all clusters
+	// of the previous waves completed their rotation of the current cycle. Clusters matching no selector form the last wave.
+	Waves []string
+	// DueTimeout defaults to DefaultDueTimeout.
+	DueTimeout time.Duration
+}
+
+// Coordinator limits the number of clusters rotating their encryption key at the same time across all reconciliations.
+// The rotations in progress are counted from the encryption provider config secrets, rotations started by this
+// coordinator are reserved until the secret with the rotation in progress is seen, so a stale cache never exceeds the limit.
+type Coordinator struct {
+	ctrlClient             client.Client
+	maxConcurrentRotations int
+	jitter                 float64
+	reservationTimeout     time.Duration
+	waves                  []labels.Selector
+	dueTimeout             time.Duration
+
+	mu       sync.Mutex
+	reserved map[client.ObjectKey]time.Time
+	// due are the clusters which want to start a rotation, with the time they were last reconciled
+	due map[client.ObjectKey]time.Time
+	// notPeriodic are the clusters without periodic key rotation, they never hold back the cycle of later waves
+	notPeriodic map[client.ObjectKey]bool
+}
+
+func New(c Config) (*Coordinator, error) {
+	if c.CtrlClient == nil {
+		return nil, errors.New("ctrlClient cannot be nil")
+	}
+	if c.MaxConcurrentRotations < 0 {
+		return nil, microerror.Mask(fmt.Errorf("max concurrent rotations must not be negative, got %d", c.MaxConcurrentRotations))
+	}
+	if c.Jitter < 0 || c.Jitter >= 1 {
+		return nil, microerror.Mask(fmt.Errorf("rotation jitter must be between 0 and 1, got %v", c.Jitter))
+	}
+	if c.ReservationTimeout <= 0 {
+		c.ReservationTimeout = DefaultReservationTimeout
+	}
+	if c.DueTimeout <= 0 {
+		c.DueTimeout = DefaultDueTimeout
+	}
+
+	var waves []labels.Selector
+	for _, w := range c.Waves {
+		selector, err := labels.Parse(w)
+		if err != nil {
+			return nil, microerror.Mask(fmt.Errorf("invalid rotation wave %q: %s", w, err))
+		}
+		waves = append(waves, selector)
+	}
+
+	return &Coordinator{
+		ctrlClient:             c.CtrlClient,
+		maxConcurrentRotations: c.MaxConcurrentRotations,
+		jitter:                 c.Jitter,
+		reservationTimeout:     c.ReservationTimeout,
+		waves:                  waves,
+		dueTimeout:             c.DueTimeout,
+		reserved:               map[client.ObjectKey]time.Time{},
+		due:                    map[client.ObjectKey]time.Time{},
+		notPeriodic:            map[client.ObjectKey]bool{},
+	}, nil
+}
+
+// ParseWaves splits the waves flag into the label selectors of the waves, waves are separated by semicolons
+func ParseWaves(waves string) []string {
+	var selectors []string
+	for _, w := range strings.Split(waves, ";") {
+		if w = strings.TrimSpace(w); w != "" {
+			selectors = append(selectors, w)
+		}
+	}
+
+	return selectors
+}
+
+// Acquire returns true if the cluster can start a key rotation and reserves a slot for it, the reservation also
+// holds back later waves until the rotation is visible, otherwise it returns the number of rotations in progress
+func (c *Coordinator) Acquire(ctx context.Context, cluster client.ObjectKey, now time.Time) (bool, int, error) {
+	if c == nil || (c.maxConcurrentRotations == 0 && len(c.waves) == 0) {
+		return true, 0, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	inProgress, err := c.inProgress(ctx, now)
+	if err != nil {
+		return false, 0, microerror.Mask(err)
+	}
+
+	if c.maxConcurrentRotations > 0 && !inProgress[cluster] && len(inProgress) >= c.maxConcurrentRotations {
+		return false, len(inProgress), nil
+	}
+
+	c.reserved[cluster] = now
+	delete(c.due, cluster)
+	return true, len(inProgress), nil
+}
+
+// wave returns the index of the first wave selecting the labels, clusters matching no wave are in the last wave
+func (c *Coordinator) wave(clusterLabels map[string]string) int {
+	for i, selector := range c.waves {
+		if selector.Matches(labels.Set(clusterLabels)) {
+			return i
+		}
+	}
+
+	return len(c.waves)
+}
+
+// SetPeriodic records if the key of the cluster is rotated periodically, clusters are periodic until reported otherwise
+func (c *Coordinator) SetPeriodic(cluster client.ObjectKey, periodic bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if periodic {
+		delete(c.notPeriodic, cluster)
+	} else {
+		c.notPeriodic[cluster] = true
+	}
+}
+
+// WaveReady records that the cluster wants to start a rotation and returns true if all clusters of the previous waves
+// completed their rotation, otherwise the reason the cluster has to wait is returned.
+// A previous wave is not completed while one of its clusters wants to start a rotation, rotates, or its last rotation failed or was cancelled.
+// The cycle of the rotation started with the last rotation of the cluster, every periodically rotated cluster of the previous waves
+// has to complete a rotation after it. A zero cycle start skips this check, e.g. for forced rotations.
+func (c *Coordinator) WaveReady(ctx context.Context, cluster *capi.Cluster, cycleStart time.Time, now time.Time) (bool, string, error) {
+	if c == nil || len(c.waves) == 0 {
+		return true, "", nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.due[client.ObjectKeyFromObject(cluster)] = now
+
+	wave := c.wave(cluster.Labels)
+	if wave == 0 {
+		return true, "", nil
+	}
+
+	var clusters capi.ClusterList
+	err := c.ctrlClient.List(ctx, &clusters)
+	if err != nil {
+		return false, "", microerror.Mask(err)
+	}
+	var secrets corev1.SecretList
+	err = c.ctrlClient.List(ctx, &secrets, client.MatchingLabels{label.ManagedBy: project.Name()})
+	if err != nil {
+		return false, "", microerror.Mask(err)
+	}
+	secretsByCluster := map[client.ObjectKey]*corev1.Secret{}
+	for i := range secrets.Items {
+		clusterName, ok := key.ClusterNameFromSecretName(secrets.Items[i].Name)
+		if ok && secrets.Items[i].Name == key.SecretName(clusterName) {
+			secretsByCluster[client.ObjectKey{Namespace: secrets.Items[i].Namespace, Name: clusterName}] = &secrets.Items[i]
+		}
+	}
+
+	for i := range clusters.Items {
+		previous := &clusters.Items[i]
+		previousWave := c.wave(previous.Labels)
+		if previousWave >= wave || previous.DeletionTimestamp != nil {
+			continue
+		}
+		previousKey := client.ObjectKeyFromObject(previous)
+
+		if dueAt, ok := c.due[previousKey]; ok && now.Sub(dueAt) <= c.dueTimeout {
+			return false, fmt.Sprintf("cluster %s of wave %d did not start its rotation yet", previousKey, previousWave), nil
+		}
+		if reservedAt, ok := c.reserved[previousKey]; ok && now.Sub(reservedAt) <= c.reservationTimeout {
+			return false, fmt.Sprintf("cluster %s of wave %d is rotating", previousKey, previousWave), nil
+		}
+
+		secret, ok := secretsByCluster[previousKey]
+		if !ok {
+			continue
+		}
+		if _, ok := secret.Annotations[annotation.EncryptionRotationInProgress]; ok {
+			return false, fmt.Sprintf("cluster %s of wave %d is rotating", previousKey, previousWave), nil
+		}
+		var status v1alpha1.RotationStatus
+		if v, ok := secret.Annotations[v1alpha1.RotationStatusAnnotation]; ok && json.Unmarshal([]byte(v), &status) == nil {
+			switch status.Phase {
+			case v1alpha1.RotationPhaseFailed, v1alpha1.RotationPhaseCancelled:
+				return false, fmt.Sprintf("last rotation of cluster %s of wave %d is %s", previousKey, previousWave, strings.ToLower(string(status.Phase))), nil
+			}
+		}
+		if !cycleStart.IsZero() && !c.notPeriodic[previousKey] && !lastRotation(secret).After(cycleStart) {
+			return false, fmt.Sprintf("cluster %s of wave %d did not rotate its key since %s", previousKey, previousWave, cycleStart.UTC().Format(time.RFC3339)), nil
+		}
+	}
+
+	return true, "", nil
+}
+
+// lastRotation returns the time of the last key rotation of the cluster, for clusters which were never rotated
+// the creation timestamp of the secret is used
+func lastRotation(secret *corev1.Secret) time.Time {
+	t, ok := secret.Annotations[annotation.EncryptionLastRotation]
+	if !ok {
+		return secret.CreationTimestamp.Time
+	}
+
+	last, err := time.Parse(time.RFC3339, t)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return last
+}
+
+// inProgress returns the clusters with a rotation in progress and drops the reservations which are visible or expired
+func (c *Coordinator) inProgress(ctx context.Context, now time.Time) (map[client.ObjectKey]bool, error) {
+	var secrets corev1.SecretList
+	err := c.ctrlClient.List(ctx, &secrets, client.MatchingLabels{label.ManagedBy: project.Name()})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	inProgress := map[client.ObjectKey]bool{}
+	for _, secret := range secrets.Items {
+		clusterName, ok := key.ClusterNameFromSecretName(secret.Name)
+		if !ok || secret.Name != key.SecretName(clusterName) {
+			continue
+		}
+		if _, ok := secret.Annotations[annotation.EncryptionRotationInProgress]; ok {
+			cluster := client.ObjectKey{Namespace: secret.Namespace, Name: clusterName}
+			inProgress[cluster] = true
+			delete(c.reserved, cluster)
+		}
+	}
+
+	for cluster, reservedAt := range c.reserved {
+		if now.Sub(reservedAt) > c.reservationTimeout {
+			delete(c.reserved, cluster)
+			continue
+		}
+		inProgress[cluster] = true
+	}
+
+	return inProgress, nil
+}
+
+// Jitter returns the time by which the rotation of the cluster starts before the end of the period,
+// it is derived from the namespace and name of the cluster, so it is stable across reconciliations and restarts
+func (c *Coordinator) Jitter(cluster client.ObjectKey, period time.Duration) time.Duration {
+	if c == nil || c.jitter == 0 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(cluster.String()))
+	fraction := float64(h.Sum64()%10000) / 10000
+
+	return time.Duration(float64(period) * c.jitter * fraction)
+}
@@ -0,0 +1,303 @@
+package fleet
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+	"github.com/giantswarm/encryption-provider-operator/pkg/key"
+	"github.com/giantswarm/encryption-provider-operator/pkg/label"
+	"github.com/giantswarm/encryption-provider-operator/pkg/project"
+)
+
+func testSecret(clusterName string, inProgress bool) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.SecretName(clusterName),
+			Namespace: "org-test",
+			Labels:    map[string]string{label.ManagedBy: project.Name()},
+		},
+	}
+	if inProgress {
+		secret.Annotations = map[string]string{annotation.EncryptionRotationInProgress: "true"}
+	}
+
+	return secret
+}
+
+func testRotatedSecret(clusterName string, lastRotation time.Time) *corev1.Secret {
+	secret := testSecret(clusterName, false)
+	secret.Annotations = map[string]string{annotation.EncryptionLastRotation: lastRotation.Format(time.RFC3339)}
+
+	return secret
+}
+
+func Test_Coordinator_Acquire(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+
+	c, err := New(Config{
+		CtrlClient: fake.NewClientBuilder().WithObjects(
+			testSecret("a", true),
+			testSecret("b", false),
+		).Build(),
+		MaxConcurrentRotations: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name               string
+		cluster            string
+		now                time.Time
+		expectedAcquired   bool
+		expectedInProgress int
+	}{
+		{
+			name:               "case 0: free slot",
+			cluster:            "b",
+			now:                now,
+			expectedAcquired:   true,
+			expectedInProgress: 1,
+		},
+		{
+			name:               "case 1: all slots used by a rotation and a reservation",
+			cluster:            "c",
+			now:                now,
+			expectedInProgress: 2,
+		},
+		{
+			name:               "case 2: cluster already rotating",
+			cluster:            "a",
+			now:                now,
+			expectedAcquired:   true,
+			expectedInProgress: 2,
+		},
+		{
+			name:               "case 3: reservation expired",
+			cluster:            "c",
+			now:                now.Add(2 * DefaultReservationTimeout),
+			expectedAcquired:   true,
+			expectedInProgress: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			acquired, inProgress, err := c.Acquire(ctx, client.ObjectKey{Namespace: "org-test", Name: tc.cluster}, tc.now)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if acquired != tc.expectedAcquired {
+				t.Fatalf("expected acquired %t but got %t", tc.expectedAcquired, acquired)
+			}
+			if inProgress != tc.expectedInProgress {
+				t.Fatalf("expected %d rotations in progress but got %d", tc.expectedInProgress, inProgress)
+			}
+		})
+	}
+}
+
+func Test_Coordinator_Acquire_unlimited(t *testing.T) {
+	var c *Coordinator
+	acquired, _, err := c.Acquire(context.Background(), client.ObjectKey{Namespace: "org-test", Name: "a"}, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !acquired {
+		t.Fatalf("expected rotations without coordinator to be unlimited")
+	}
+}
+
+func Test_Coordinator_Jitter(t *testing.T) {
+	period := 100 * time.Hour
+	c, err := New(Config{CtrlClient: fake.NewClientBuilder().Build(), Jitter: 0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := c.Jitter(client.ObjectKey{Namespace: "org-test", Name: "a"}, period)
+	if a != c.Jitter(client.ObjectKey{Namespace: "org-test", Name: "a"}, period) {
+		t.Fatalf("expected jitter to be stable for the same cluster")
+	}
+	if a < 0 || a >= 10*time.Hour {
+		t.Fatalf("expected jitter within 10%% of the period but got %s", a)
+	}
+	if a == c.Jitter(client.ObjectKey{Namespace: "org-test", Name: "b"}, period) {
+		t.Fatalf("expected different jitter for different clusters")
+	}
+
+	var disabled *Coordinator
+	if j := disabled.Jitter(client.ObjectKey{Namespace: "org-test", Name: "a"}, period); j != 0 {
+		t.Fatalf("expected no jitter without coordinator but got %s", j)
+	}
+}
+
+func Test_New(t *testing.T) {
+	_, err := New(Config{CtrlClient: fake.NewClientBuilder().Build(), Jitter: 1})
+	if err == nil {
+		t.Fatalf("expected error for jitter of the whole period")
+	}
+	_, err = New(Config{CtrlClient: fake.NewClientBuilder().Build(), MaxConcurrentRotations: -1})
+	if err == nil {
+		t.Fatalf("expected error for negative max concurrent rotations")
+	}
+}
+
+func testCluster(name string, env string) *capi.Cluster {
+	cluster := &capi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "org-test"}}
+	if env != "" {
+		cluster.Labels = map[string]string{"environment": env}
+	}
+
+	return cluster
+}
+
+func Test_Coordinator_WaveReady(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := capi.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	dev := testCluster("dev", "dev")
+	staging := testCluster("staging", "staging")
+	production := testCluster("production", "")
+	failed := testSecret("staging", false)
+	failed.Annotations = map[string]string{v1alpha1.RotationStatusAnnotation: `{"phase":"Failed","failedPhase":"RewritingSecrets"}`}
+	now := time.Now()
+	cycleStart := now.Add(-24 * time.Hour)
+
+	testCases := []struct {
+		name            string
+		objects         []client.Object
+		due             []*capi.Cluster
+		acquired        []*capi.Cluster
+		notPeriodic     []*capi.Cluster
+		cluster         *capi.Cluster
+		cycleStart      time.Time
+		expectedReady   bool
+		expectedMatcher string
+	}{
+		{
+			name:          "case 0: first wave",
+			cluster:       dev,
+			cycleStart:    cycleStart,
+			expectedReady: true,
+		},
+		{
+			name:            "case 1: previous wave not due yet in the current cycle",
+			objects:         []client.Object{testRotatedSecret("dev", cycleStart.Add(-time.Hour)), testRotatedSecret("staging", cycleStart)},
+			cluster:         staging,
+			cycleStart:      cycleStart,
+			expectedMatcher: "org-test/dev of wave 0 did not rotate its key since",
+		},
+		{
+			name:          "case 2: previous wave completed",
+			objects:       []client.Object{testSecret("dev", false), testSecret("staging", false)},
+			cluster:       staging,
+			expectedReady: true,
+		},
+		{
+			name:            "case 3: previous wave rotating",
+			objects:         []client.Object{testSecret("dev", true)},
+			cluster:         staging,
+			expectedMatcher: "org-test/dev of wave 0 is rotating",
+		},
+		{
+			name:            "case 4: previous wave wants to rotate",
+			due:             []*capi.Cluster{dev},
+			cluster:         production,
+			expectedMatcher: "org-test/dev of wave 0 did not start its rotation yet",
+		},
+		{
+			name:            "case 5: previous wave started rotation not in cache yet",
+			due:             []*capi.Cluster{dev},
+			acquired:        []*capi.Cluster{dev},
+			cluster:         staging,
+			expectedMatcher: "org-test/dev of wave 0 is rotating",
+		},
+		{
+			name:            "case 6: rotation of previous wave failed",
+			objects:         []client.Object{failed},
+			cluster:         production,
+			expectedMatcher: "org-test/staging of wave 1 is failed",
+		},
+		{
+			name:          "case 7: previous wave rotated in the current cycle",
+			objects:       []client.Object{testRotatedSecret("dev", cycleStart.Add(time.Hour)), testRotatedSecret("staging", cycleStart)},
+			cluster:       staging,
+			cycleStart:    cycleStart,
+			expectedReady: true,
+		},
+		{
+			name:          "case 8: previous wave not rotated periodically",
+			objects:       []client.Object{testRotatedSecret("dev", cycleStart.Add(-time.Hour)), testRotatedSecret("staging", cycleStart)},
+			notPeriodic:   []*capi.Cluster{dev},
+			cluster:       staging,
+			cycleStart:    cycleStart,
+			expectedReady: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := append([]client.Object{dev.DeepCopy(), staging.DeepCopy(), production.DeepCopy()}, tc.objects...)
+			c, err := New(Config{
+				CtrlClient: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+				Waves:      ParseWaves("environment=dev; environment=staging"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, cluster := range tc.notPeriodic {
+				c.SetPeriodic(client.ObjectKeyFromObject(cluster), false)
+			}
+			for _, cluster := range tc.due {
+				_, _, err = c.WaveReady(ctx, cluster, time.Time{}, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, cluster := range tc.acquired {
+				_, _, err = c.Acquire(ctx, client.ObjectKeyFromObject(cluster), now)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ready, reason, err := c.WaveReady(ctx, tc.cluster, tc.cycleStart, now)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if ready != tc.expectedReady {
+				t.Fatalf("expected ready %t but got %t with reason %q", tc.expectedReady, ready, reason)
+			}
+			if !strings.Contains(reason, tc.expectedMatcher) {
+				t.Fatalf("expected reason matching %q but got %q", tc.expectedMatcher, reason)
+			}
+		})
+	}
+}
+
+func Test_New_invalidWave(t *testing.T) {
+	_, err := New(Config{CtrlClient: fake.NewClientBuilder().Build(), Waves: []string{"environment in (dev"}})
+	if err == nil {
+		t.Fatalf("expected error for invalid wave selector")
+	}
+}
@@ -1,18 +1,10 @@
 package key
 
 import (
-	"context"
-	"fmt"
-	"os"
+	"strings"
+	"time"
 
-	chartv1 "github.com/giantswarm/apiextensions-application/api/v1alpha1"
-	"github.com/giantswarm/microerror"
 	corev1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/runtime"
-	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/clientcmd"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
@@ -24,73 +16,49 @@ var MasterNodeLabels = []string{
 	"node-role.kubernetes.io/control-plane",
 }
 
-func SecretName(clusterName string) string {
-	return fmt.Sprintf("%s-encryption-provider-config", clusterName)
-}
-
-// GetWCK8sClient will return workload cluster k8s controller-runtime client
-func GetWCK8sClient(ctx context.Context, ctrlClient client.Client, clusterName string, clusterNamespace string) (client.Client, error) {
-	var err error
-	var kubeconfig []byte
-	var secret corev1.Secret
-	{
-		err = ctrlClient.Get(ctx, client.ObjectKey{
-			Name:      fmt.Sprintf("%s-kubeconfig", clusterName),
-			Namespace: clusterNamespace,
-		},
-			&secret)
-
-		if apierrors.IsNotFound(err) {
-			// in legacy gs the kubeconfig is in namespace equal to cluster ID
-			err = ctrlClient.Get(ctx, client.ObjectKey{
-				Name:      fmt.Sprintf("%s-kubeconfig", clusterName),
-				Namespace: clusterName,
-			},
-				&secret)
-			if err != nil {
-				return nil, microerror.Mask(err)
-			}
-			kubeconfig = secret.Data["kubeConfig"]
-		} else if err != nil {
-			return nil, microerror.Mask(err)
-		} else {
-			kubeconfig = secret.Data["value"]
+// IsNodeReady returns true if the Ready condition of the node is True
+func IsNodeReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
 		}
 	}
-	err = os.WriteFile(tempKubeconfigFileName(clusterName), kubeconfig, 0600)
-	if err != nil {
-		return nil, microerror.Mask(err)
-	}
 
-	config, err := clientcmd.BuildConfigFromFlags("", tempKubeconfigFileName(clusterName))
-	if err != nil {
-		return nil, microerror.Mask(err)
-	}
+	return false
+}
 
-	scheme := runtime.NewScheme()
-	_ = chartv1.AddToScheme(scheme)
-	_ = clientgoscheme.AddToScheme(scheme)
+const (
+	secretSuffix           = "-encryption-provider-config"
+	legacySecretSuffix     = "-encryption"
+	keyHistorySecretSuffix = "-encryption-key-history"
+)
 
-	wcClient, err := client.New(config, client.Options{Scheme: scheme})
-	if err != nil {
-		return nil, microerror.Mask(err)
-	}
+func SecretName(clusterName string) string {
+	return clusterName + secretSuffix
+}
 
-	return wcClient, nil
+// ArchiveSecretName returns the name of the secret the key material of a deleted cluster is archived to at the given time
+func ArchiveSecretName(clusterName string, archivedAt time.Time) string {
+	return SecretName(clusterName) + "-archive-" + archivedAt.UTC().Format("20060102150405")
 }
 
-// CleanWCK8sKubeconfig will clean old kubeconfig file to avoid issue when cluster is recreated with same ID
-func CleanWCK8sKubeconfig(clusterName string) error {
-	err := os.Remove(tempKubeconfigFileName(clusterName))
-	if os.IsNotExist(err) {
-		// we ignore if the file is already deleted
-	} else if err != nil {
-		return microerror.Mask(err)
-	}
+// KeyHistorySecretName returns the name of the secret the retired encryption keys of the cluster are escrowed to
+func KeyHistorySecretName(clusterName string) string {
+	return clusterName + keyHistorySecretSuffix
+}
 
-	return nil
+// LegacySecretName returns the name of the secret with the encryption key of the legacy product
+func LegacySecretName(clusterName string) string {
+	return clusterName + legacySecretSuffix
 }
 
-func tempKubeconfigFileName(clusterName string) string {
-	return fmt.Sprintf("/tmp/kubeconfig-%s", clusterName)
+// ClusterNameFromSecretName returns the name of the cluster of the encryption provider config secret or the legacy secret
+func ClusterNameFromSecretName(secretName string) (string, bool) {
+	for _, suffix := range []string{secretSuffix, legacySecretSuffix} {
+		if clusterName, ok := strings.CutSuffix(secretName, suffix); ok && clusterName != "" {
+			return clusterName, true
+		}
+	}
+
+	return "", false
 }
@@ -0,0 +1,80 @@
+package key
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_ClusterNameFromSecretName(t *testing.T) {
+	testCases := []struct {
+		name        string
+		secretName  string
+		clusterName string
+		ok          bool
+	}{
+		{
+			name:        "case 0: encryption provider config secret",
+			secretName:  SecretName("test"),
+			clusterName: "test",
+			ok:          true,
+		},
+		{
+			name:        "case 1: legacy encryption secret",
+			secretName:  LegacySecretName("abc12"),
+			clusterName: "abc12",
+			ok:          true,
+		},
+		{
+			name:       "case 2: kubeconfig secret",
+			secretName: "test-kubeconfig",
+		},
+		{
+			name:       "case 3: suffix only",
+			secretName: "-encryption",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clusterName, ok := ClusterNameFromSecretName(tc.secretName)
+			if ok != tc.ok || clusterName != tc.clusterName {
+				t.Fatalf("expected cluster %q (%t) but got %q (%t)", tc.clusterName, tc.ok, clusterName, ok)
+			}
+		})
+	}
+}
+
+func Test_IsNodeReady(t *testing.T) {
+	testCases := []struct {
+		name       string
+		conditions []corev1.NodeCondition
+		ready      bool
+	}{
+		{
+			name:       "case 0: ready node",
+			conditions: []corev1.NodeCondition{{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse}, {Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			ready:      true,
+		},
+		{
+			name:       "case 1: not ready node",
+			conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}},
+		},
+		{
+			name:       "case 2: unknown readiness",
+			conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionUnknown}},
+		},
+		{
+			name: "case 3: node without conditions",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &corev1.Node{Status: corev1.NodeStatus{Conditions: tc.conditions}}
+			if ready := IsNodeReady(node); ready != tc.ready {
+				t.Fatalf("expected ready %t but got %t", tc.ready, ready)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+)
+
+const (
+	namespace = "encryption_provider_operator"
+
+	clusterNamespaceLabel = "cluster_namespace"
+	clusterNameLabel      = "cluster_name"
+	phaseLabel            = "phase"
+	resourceLabel         = "resource"
+)
+
+var (
+	keyAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "key_age_seconds",
+			Help:      "Age of the current encryption key, based on the last rotation or the creation of the encryption provider config.",
+		},
+		[]string{clusterNamespaceLabel, clusterNameLabel},
+	)
+	keyRotationPeriod = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "key_rotation_period_seconds",
+			Help:      "Configured key rotation period of the cluster.",
+		},
+		[]string{clusterNamespaceLabel, clusterNameLabel},
+	)
+	rotationPhase = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rotation_phase",
+			Help:      "Current key rotation phase of the cluster, the gauge of the current phase is 1.",
+		},
+		[]string{clusterNamespaceLabel, clusterNameLabel, phaseLabel},
+	)
+	controlPlaneNodes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "control_plane_nodes",
+			Help:      "Number of control plane nodes found in the workload cluster during the last convergence check.",
+		},
+		[]string{clusterNamespaceLabel, clusterNameLabel},
+	)
+	controlPlaneNodesUpToDate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "control_plane_nodes_up_to_date",
+			Help:      "Number of control plane nodes reporting the hash of the latest encryption provider config.",
+		},
+		[]string{clusterNamespaceLabel, clusterNameLabel},
+	)
+	rewrittenResources = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rewritten_resources_total",
+			Help:      "Number of objects rewritten in the workload cluster during key rotations by resource.",
+		},
+		[]string{clusterNamespaceLabel, clusterNameLabel, resourceLabel},
+	)
+	rotationFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rotation_failures_total",
+			Help:      "Number of failed key rotation steps by phase.",
+		},
+		[]string{clusterNamespaceLabel, clusterNameLabel, phaseLabel},
+	)
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		keyAge,
+		keyRotationPeriod,
+		rotationPhase,
+		controlPlaneNodes,
+		controlPlaneNodesUpToDate,
+		rewrittenResources,
+		rotationFailures,
+	)
+}
+
+// SetKeyAge sets the age of the current key and the rotation period of the cluster.
+func SetKeyAge(clusterNamespace, clusterName string, age time.Duration, period time.Duration) {
+	keyAge.WithLabelValues(clusterNamespace, clusterName).Set(age.Seconds())
+	keyRotationPeriod.WithLabelValues(clusterNamespace, clusterName).Set(period.Seconds())
+}
+
+// SetRotationPhase sets the gauge of the current phase to 1 and all other phases to 0.
+func SetRotationPhase(clusterNamespace, clusterName string, phase v1alpha1.RotationPhase) {
+	for _, p := range v1alpha1.RotationPhases {
+		value := 0.0
+		if p == phase {
+			value = 1
+		}
+		rotationPhase.WithLabelValues(clusterNamespace, clusterName, string(p)).Set(value)
+	}
+}
+
+// SetControlPlaneNodes sets the number of control plane nodes and how many of them use the latest config.
+func SetControlPlaneNodes(clusterNamespace, clusterName string, total int, upToDate int) {
+	controlPlaneNodes.WithLabelValues(clusterNamespace, clusterName).Set(float64(total))
+	controlPlaneNodesUpToDate.WithLabelValues(clusterNamespace, clusterName).Set(float64(upToDate))
+}
+
+// AddRewrittenResources increases the number of rewritten objects of the resource.
+func AddRewrittenResources(clusterNamespace, clusterName string, resource string, count int) {
+	rewrittenResources.WithLabelValues(clusterNamespace, clusterName, resource).Add(float64(count))
+}
+
+// IncRotationFailures increases the number of failures of the given rotation phase.
+func IncRotationFailures(clusterNamespace, clusterName string, phase v1alpha1.RotationPhase) {
+	rotationFailures.WithLabelValues(clusterNamespace, clusterName, string(phase)).Inc()
+}
+
+// DeleteCluster removes all series of the cluster, it is called when the cluster is deleted.
+func DeleteCluster(clusterNamespace, clusterName string) {
+	labels := prometheus.Labels{
+		clusterNamespaceLabel: clusterNamespace,
+		clusterNameLabel:      clusterName,
+	}
+
+	keyAge.DeletePartialMatch(labels)
+	keyRotationPeriod.DeletePartialMatch(labels)
+	rotationPhase.DeletePartialMatch(labels)
+	controlPlaneNodes.DeletePartialMatch(labels)
+	controlPlaneNodesUpToDate.DeletePartialMatch(labels)
+	rewrittenResources.DeletePartialMatch(labels)
+	rotationFailures.DeletePartialMatch(labels)
+}
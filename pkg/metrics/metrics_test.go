@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/giantswarm/encryption-provider-operator/api/v1alpha1"
+)
+
+func Test_RotationPhaseChange(t *testing.T) {
+	SetRotationPhase("org-test", "abc12", v1alpha1.RotationPhaseRewritingSecrets)
+	IncRotationFailures("org-test", "abc12", v1alpha1.RotationPhaseRewritingSecrets)
+	SetRotationPhase("org-test", "abc12", v1alpha1.RotationPhaseFailed)
+
+	for _, p := range v1alpha1.RotationPhases {
+		expected := 0.0
+		if p == v1alpha1.RotationPhaseFailed {
+			expected = 1
+		}
+		value := testutil.ToFloat64(rotationPhase.WithLabelValues("org-test", "abc12", string(p)))
+		if value != expected {
+			t.Fatalf("expected gauge of phase %s to be %v but got %v", p, expected, value)
+		}
+	}
+
+	failures := testutil.ToFloat64(rotationFailures.WithLabelValues("org-test", "abc12", string(v1alpha1.RotationPhaseRewritingSecrets)))
+	if failures != 1 {
+		t.Fatalf("expected 1 failure of phase %s but got %v", v1alpha1.RotationPhaseRewritingSecrets, failures)
+	}
+
+	DeleteCluster("org-test", "abc12")
+	if count := testutil.CollectAndCount(rotationPhase) + testutil.CollectAndCount(rotationFailures); count != 0 {
+		t.Fatalf("expected all series of the deleted cluster to be removed but got %d", count)
+	}
+}
@@ -18,7 +18,7 @@ var (
 
 func init() {
 	defaultRecorder = new(record.FakeRecorder)
-	caser = cases.Title(language.AmericanEnglish)
+	caser = cases.Title(language.AmericanEnglish, cases.NoLower)
 }
 
 // InitFromRecorder initializes the global default recorder. It can only be called once.
@@ -0,0 +1,284 @@
+package remote
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+
+	chartv1 "github.com/giantswarm/apiextensions-application/api/v1alpha1"
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+type Config struct {
+	// CtrlClient is the management cluster client used to read the kubeconfig secrets of the workload clusters.
+	CtrlClient client.Client
+	// CacheByObject restricts the objects cached for the watches on the workload clusters, e.g. to a namespace.
+	CacheByObject map[client.Object]cache.ByObject
+	// Logger reports caches of workload clusters which stopped with an error.
+	Logger logr.Logger
+}
+
+// ClusterCache keeps the rest configs and clients of the workload clusters in memory, keyed by the namespace and name of the cluster.
+// A cached client is rebuilt when the kubeconfig secret of the cluster changed and dropped when the cluster is deleted,
+// together with the watches on the workload cluster.
+type ClusterCache struct {
+	ctrlClient    client.Client
+	cacheByObject map[client.Object]cache.ByObject
+	scheme        *runtime.Scheme
+	logger        logr.Logger
+
+	mu       sync.Mutex
+	clusters map[client.ObjectKey]*cachedCluster
+}
+
+type cachedCluster struct {
+	// kubeconfigSum is the checksum of the kubeconfig the config and client were built from.
+	kubeconfigSum [sha256.Size]byte
+	restConfig    *rest.Config
+	client        client.Client
+
+	// cache is the informer cache of the watches, it is started with the first watch.
+	cache       cache.Cache
+	cancelCache context.CancelFunc
+	watches     map[string]bool
+}
+
+// Watcher is a controller which can watch sources, e.g. controller.Controller.
+type Watcher interface {
+	Watch(src source.Source) error
+}
+
+// WatchInput describes a watch on a workload cluster.
+type WatchInput struct {
+	// Name identifies the watch, a watch with the same name is only added once per cluster.
+	Name string
+	// Watcher is the controller the events are sent to.
+	Watcher Watcher
+	// Kind is the kind of the watched objects.
+	Kind client.Object
+	// EventHandler maps the events to reconcile requests.
+	EventHandler handler.EventHandler
+	// Predicates filter the events.
+	Predicates []predicate.Predicate
+}
+
+// NewClusterCache returns a cache which reads the kubeconfig secrets of the workload clusters with the management cluster client
+func NewClusterCache(c Config) (*ClusterCache, error) {
+	if c.CtrlClient == nil {
+		return nil, errors.New("ctrlClient cannot be nil")
+	}
+
+	scheme := runtime.NewScheme()
+	err := chartv1.AddToScheme(scheme)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	err = clientgoscheme.AddToScheme(scheme)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return &ClusterCache{
+		ctrlClient:    c.CtrlClient,
+		cacheByObject: c.CacheByObject,
+		scheme:        scheme,
+		logger:        c.Logger,
+		clusters:      map[client.ObjectKey]*cachedCluster{},
+	}, nil
+}
+
+// GetClient returns the controller-runtime client of the workload cluster
+func (c *ClusterCache) GetClient(ctx context.Context, cluster client.ObjectKey) (client.Client, error) {
+	cached, err := c.get(ctx, cluster)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return cached.client, nil
+}
+
+// GetRestConfig returns a copy of the rest config of the workload cluster, so it can be changed by the caller
+func (c *ClusterCache) GetRestConfig(ctx context.Context, cluster client.ObjectKey) (*rest.Config, error) {
+	cached, err := c.get(ctx, cluster)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return rest.CopyConfig(cached.restConfig), nil
+}
+
+// Watch adds a watch on the workload cluster to the controller of the input, the watch is only added once per cluster
+// and is stopped when the cluster is deleted from the cache or its kubeconfig changed
+func (c *ClusterCache) Watch(ctx context.Context, cluster client.ObjectKey, input WatchInput) error {
+	cached, err := c.get(ctx, cluster)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.clusters[cluster] != cached {
+		// the cluster was deleted or rebuilt in the meantime, the watch is added in the next reconciliation loop
+		return nil
+	}
+	if cached.watches[input.Name] {
+		return nil
+	}
+
+	if cached.cache == nil {
+		remoteCache, err := cache.New(cached.restConfig, cache.Options{
+			Scheme:   c.scheme,
+			ByObject: c.cacheByObject,
+		})
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		// the cache lives as long as the cluster is cached, not as long as the reconciliation
+		cacheCtx, cancel := context.WithCancel(context.Background())
+		go c.runCache(cacheCtx, cluster, cached, remoteCache)
+		cached.cache = remoteCache
+		cached.cancelCache = cancel
+	}
+
+	err = input.Watcher.Watch(source.Kind(cached.cache, input.Kind, input.EventHandler, input.Predicates...))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	cached.watches[input.Name] = true
+
+	return nil
+}
+
+// runCache runs the informer cache of the watches until it is stopped, a cache which failed is dropped
+// together with the cluster, so the watches are added to a new cache in the next reconciliation loop
+func (c *ClusterCache) runCache(ctx context.Context, cluster client.ObjectKey, cached *cachedCluster, remoteCache cache.Cache) {
+	err := remoteCache.Start(ctx)
+	if err == nil {
+		return
+	}
+	c.logger.Error(err, "cache of workload cluster stopped", "cluster", cluster.String())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.clusters[cluster] == cached && cached.cache == remoteCache {
+		cached.stop()
+		delete(c.clusters, cluster)
+	}
+}
+
+// StopWatches stops the watches on the workload cluster, the client is kept
+func (c *ClusterCache) StopWatches(cluster client.ObjectKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.clusters[cluster]; ok {
+		cached.stop()
+		cached.cache = nil
+		cached.cancelCache = nil
+		cached.watches = map[string]bool{}
+	}
+}
+
+// Delete drops the cached config and client of the workload cluster and stops its watches
+func (c *ClusterCache) Delete(cluster client.ObjectKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.clusters[cluster]; ok {
+		cached.stop()
+		delete(c.clusters, cluster)
+	}
+}
+
+func (c *ClusterCache) get(ctx context.Context, cluster client.ObjectKey) (*cachedCluster, error) {
+	kubeconfig, err := c.kubeconfig(ctx, cluster)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	kubeconfigSum := sha256.Sum256(kubeconfig)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.clusters[cluster]; ok {
+		if cached.kubeconfigSum == kubeconfigSum {
+			return cached, nil
+		}
+		cached.stop()
+	}
+
+	// the kubeconfig is new or changed, e.g. because the CA or credentials were rotated
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	wcClient, err := client.New(restConfig, client.Options{Scheme: c.scheme})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	cached := &cachedCluster{
+		kubeconfigSum: kubeconfigSum,
+		restConfig:    restConfig,
+		client:        wcClient,
+		watches:       map[string]bool{},
+	}
+	c.clusters[cluster] = cached
+
+	return cached, nil
+}
+
+// stop stops the watches on the workload cluster
+func (c *cachedCluster) stop() {
+	if c.cancelCache != nil {
+		c.cancelCache()
+	}
+}
+
+// kubeconfig returns the kubeconfig of the workload cluster from its kubeconfig secret
+func (c *ClusterCache) kubeconfig(ctx context.Context, cluster client.ObjectKey) ([]byte, error) {
+	var secret corev1.Secret
+	err := c.ctrlClient.Get(ctx, client.ObjectKey{
+		Name:      KubeconfigSecretName(cluster.Name),
+		Namespace: cluster.Namespace,
+	},
+		&secret)
+	if apierrors.IsNotFound(err) {
+		// in legacy gs the kubeconfig is in namespace equal to cluster ID
+		err = c.ctrlClient.Get(ctx, client.ObjectKey{
+			Name:      KubeconfigSecretName(cluster.Name),
+			Namespace: cluster.Name,
+		},
+			&secret)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		return secret.Data["kubeConfig"], nil
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return secret.Data["value"], nil
+}
+
+// KubeconfigSecretName returns the name of the kubeconfig secret of the workload cluster
+func KubeconfigSecretName(clusterName string) string {
+	return fmt.Sprintf("%s-kubeconfig", clusterName)
+}
@@ -0,0 +1,235 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+func testKubeconfig(t *testing.T, server string) []byte {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["test"] = &clientcmdapi.Cluster{Server: server}
+	config.AuthInfos["test"] = &clientcmdapi.AuthInfo{Token: "token"}
+	config.Contexts["test"] = &clientcmdapi.Context{Cluster: "test", AuthInfo: "test"}
+	config.CurrentContext = "test"
+
+	kubeconfig, err := clientcmd.Write(*config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return kubeconfig
+}
+
+func Test_ClusterCache(t *testing.T) {
+	ctx := context.Background()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: KubeconfigSecretName("test"), Namespace: "org-test"},
+		Data:       map[string][]byte{"value": testKubeconfig(t, "https://test.example.com:6443")},
+	}
+	legacySecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: KubeconfigSecretName("abc12"), Namespace: "abc12"},
+		Data:       map[string][]byte{"kubeConfig": testKubeconfig(t, "https://abc12.example.com:6443")},
+	}
+	ctrlClient := fake.NewClientBuilder().WithObjects(secret, legacySecret).Build()
+
+	cache, err := NewClusterCache(Config{CtrlClient: ctrlClient})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := client.ObjectKey{Name: "test", Namespace: "org-test"}
+	wcClient, err := cache.GetClient(ctx, cluster)
+	if err != nil {
+		t.Fatalf("failed to get client %s", err)
+	}
+
+	// the client is reused as long as the kubeconfig does not change
+	cachedClient, err := cache.GetClient(ctx, cluster)
+	if err != nil {
+		t.Fatalf("failed to get client %s", err)
+	}
+	if cachedClient != wcClient {
+		t.Fatalf("expected cached client to be reused")
+	}
+
+	// a changed kubeconfig invalidates the cached client
+	secret.Data["value"] = testKubeconfig(t, "https://new.example.com:6443")
+	err = ctrlClient.Update(ctx, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restConfig, err := cache.GetRestConfig(ctx, cluster)
+	if err != nil {
+		t.Fatalf("failed to get rest config %s", err)
+	}
+	if restConfig.Host != "https://new.example.com:6443" {
+		t.Fatalf("expected host of the changed kubeconfig but got %s", restConfig.Host)
+	}
+	newClient, err := cache.GetClient(ctx, cluster)
+	if err != nil {
+		t.Fatalf("failed to get client %s", err)
+	}
+	if newClient == wcClient {
+		t.Fatalf("expected client to be rebuilt after the kubeconfig changed")
+	}
+
+	// the returned rest config is a copy
+	restConfig.Host = "https://changed.example.com"
+	restConfig, err = cache.GetRestConfig(ctx, cluster)
+	if err != nil {
+		t.Fatalf("failed to get rest config %s", err)
+	}
+	if restConfig.Host != "https://new.example.com:6443" {
+		t.Fatalf("expected cached rest config not to be changed but got host %s", restConfig.Host)
+	}
+
+	// a deleted cluster is dropped from the cache
+	cache.Delete(cluster)
+	if _, ok := cache.clusters[cluster]; ok {
+		t.Fatalf("expected cluster to be dropped from the cache")
+	}
+
+	// legacy clusters have the kubeconfig in the namespace named after the cluster
+	restConfig, err = cache.GetRestConfig(ctx, client.ObjectKey{Name: "abc12", Namespace: "org-test"})
+	if err != nil {
+		t.Fatalf("failed to get rest config %s", err)
+	}
+	if restConfig.Host != "https://abc12.example.com:6443" {
+		t.Fatalf("expected host of the legacy kubeconfig but got %s", restConfig.Host)
+	}
+}
+
+type testWatcher struct {
+	sources []source.Source
+}
+
+func (w *testWatcher) Watch(src source.Source) error {
+	w.sources = append(w.sources, src)
+	return nil
+}
+
+func Test_ClusterCache_Watch(t *testing.T) {
+	ctx := context.Background()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: KubeconfigSecretName("test"), Namespace: "org-test"},
+		Data:       map[string][]byte{"value": testKubeconfig(t, "https://test.example.com:6443")},
+	}
+	ctrlClient := fake.NewClientBuilder().WithObjects(secret).Build()
+
+	cache, err := NewClusterCache(Config{CtrlClient: ctrlClient})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := client.ObjectKey{Name: "test", Namespace: "org-test"}
+	watcher := &testWatcher{}
+	input := WatchInput{
+		Name:         "nodes",
+		Watcher:      watcher,
+		Kind:         &corev1.Node{},
+		EventHandler: &handler.EnqueueRequestForObject{},
+	}
+
+	// a watch is only added once per cluster
+	for i := 0; i < 2; i++ {
+		err = cache.Watch(ctx, cluster, input)
+		if err != nil {
+			t.Fatalf("failed to watch %s", err)
+		}
+	}
+	if len(watcher.sources) != 1 {
+		t.Fatalf("expected 1 watch but got %d", len(watcher.sources))
+	}
+
+	// a changed kubeconfig stops the watches, they are added again to the new cache
+	secret.Data["value"] = testKubeconfig(t, "https://new.example.com:6443")
+	err = ctrlClient.Update(ctx, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cache.Watch(ctx, cluster, input)
+	if err != nil {
+		t.Fatalf("failed to watch %s", err)
+	}
+	if len(watcher.sources) != 2 {
+		t.Fatalf("expected watch to be added again after the kubeconfig changed but got %d watches", len(watcher.sources))
+	}
+
+	// stopped watches are added again to a new cache, the client is kept
+	wcClient, err := cache.GetClient(ctx, cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.StopWatches(cluster)
+	if cache.clusters[cluster].cache != nil {
+		t.Fatalf("expected cache of the watches to be stopped")
+	}
+	err = cache.Watch(ctx, cluster, input)
+	if err != nil {
+		t.Fatalf("failed to watch %s", err)
+	}
+	if len(watcher.sources) != 3 {
+		t.Fatalf("expected watch to be added again after the watches were stopped but got %d watches", len(watcher.sources))
+	}
+	cachedClient, err := cache.GetClient(ctx, cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cachedClient != wcClient {
+		t.Fatalf("expected client to be kept when the watches are stopped")
+	}
+
+	cache.Delete(cluster)
+	if len(cache.clusters) != 0 {
+		t.Fatalf("expected cluster to be dropped from the cache")
+	}
+}
+
+type failingCache struct {
+	cache.Cache
+}
+
+func (c *failingCache) Start(context.Context) error {
+	return errors.New("failed to start informers")
+}
+
+func Test_ClusterCache_runCache(t *testing.T) {
+	ctx := context.Background()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: KubeconfigSecretName("test"), Namespace: "org-test"},
+		Data:       map[string][]byte{"value": testKubeconfig(t, "https://test.example.com:6443")},
+	}
+	clusterCache, err := NewClusterCache(Config{CtrlClient: fake.NewClientBuilder().WithObjects(secret).Build()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := client.ObjectKey{Name: "test", Namespace: "org-test"}
+	cached, err := clusterCache.get(ctx, cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteCache := &failingCache{}
+	cached.cache = remoteCache
+	cached.watches["nodes"] = true
+
+	// a failed cache is dropped together with its watches
+	clusterCache.runCache(ctx, cluster, cached, remoteCache)
+	if _, ok := clusterCache.clusters[cluster]; ok {
+		t.Fatalf("expected cluster with failed cache to be dropped")
+	}
+}